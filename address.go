@@ -0,0 +1,350 @@
+package mtx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/benmcclelland/mtx/scsi/smc"
+	"github.com/pkg/errors"
+)
+
+var (
+	liNumRxp   = regexp.MustCompile(`^Number of (Medium Transport|Storage|Import/Export|Data Transfer) Element(?: Element)?s: (\d+)`)
+	liFirstRxp = regexp.MustCompile(`^First (Medium Transport|Storage|Import/Export|Data Transfer) Element Address: (\d+)`)
+)
+
+// ElementMap is the element address assignment of a Library.  It
+// translates between the element numbers used by mtx (and Slot.ID)
+// and SCSI element addresses as shown in library logs and GUIs.
+// Without DriveAddresses and SlotAddresses, elements of each type are
+// taken to have consecutive addresses from the first one.
+type ElementMap struct {
+	FirstTransport, NumTransport       int
+	FirstStorage, NumStorage           int
+	FirstImportExport, NumImportExport int
+	FirstDrive, NumDrives              int
+	// DriveAddresses, if set, is the address of each drive by mtx
+	// drive number, for changers whose addresses have gaps
+	DriveAddresses []int
+	// SlotAddresses, if set, is the address of each storage and
+	// import/export element, mtx element number n at index n-1
+	SlotAddresses []int
+}
+
+// Address returns the SCSI element address for the slot of type t
+// with mtx element number id
+func (m ElementMap) Address(t SlotType, id string) (int, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, errors.Errorf("invalid element id %q", id)
+	}
+	switch t {
+	case DataTransferElement:
+		if m.DriveAddresses != nil {
+			if n >= 0 && n < len(m.DriveAddresses) {
+				return m.DriveAddresses[n], nil
+			}
+			break
+		}
+		if n >= 0 && n < m.NumDrives {
+			return m.FirstDrive + n, nil
+		}
+	case StorageElement:
+		if m.SlotAddresses != nil {
+			if n >= 1 && n <= m.NumStorage && n <= len(m.SlotAddresses) {
+				return m.SlotAddresses[n-1], nil
+			}
+			break
+		}
+		if n >= 1 && n <= m.NumStorage {
+			return m.FirstStorage + n - 1, nil
+		}
+	case ImportExport:
+		// mtx numbers import/export elements after the storage slots
+		if m.SlotAddresses != nil {
+			if n > m.NumStorage && n <= len(m.SlotAddresses) {
+				return m.SlotAddresses[n-1], nil
+			}
+			break
+		}
+		if n > m.NumStorage && n <= m.NumStorage+m.NumImportExport {
+			return m.FirstImportExport + n - m.NumStorage - 1, nil
+		}
+	}
+	return 0, errors.Errorf("no element address for %v id %v", t, id)
+}
+
+// ID returns the slot type and mtx element number for a SCSI element
+// address
+func (m ElementMap) ID(addr int) (SlotType, string, error) {
+	if m.DriveAddresses != nil || m.SlotAddresses != nil {
+		for n, a := range m.DriveAddresses {
+			if a == addr {
+				return DataTransferElement, strconv.Itoa(n), nil
+			}
+		}
+		for i, a := range m.SlotAddresses {
+			if a == addr {
+				if i < m.NumStorage {
+					return StorageElement, strconv.Itoa(i + 1), nil
+				}
+				return ImportExport, strconv.Itoa(i + 1), nil
+			}
+		}
+		return Unknown, "", errors.Errorf("no element at address %v", FormatAddress(addr))
+	}
+	switch {
+	case addr >= m.FirstDrive && addr < m.FirstDrive+m.NumDrives:
+		return DataTransferElement, strconv.Itoa(addr - m.FirstDrive), nil
+	case addr >= m.FirstStorage && addr < m.FirstStorage+m.NumStorage:
+		return StorageElement, strconv.Itoa(addr - m.FirstStorage + 1), nil
+	case addr >= m.FirstImportExport && addr < m.FirstImportExport+m.NumImportExport:
+		return ImportExport, strconv.Itoa(addr - m.FirstImportExport + m.NumStorage + 1), nil
+	}
+	return Unknown, "", errors.Errorf("no element at address %v", FormatAddress(addr))
+}
+
+// assign fills in Slot.Address for every element of mi
+func (m ElementMap) assign(mi *MediaInfo) {
+	for _, info := range []map[string]Slot{mi.Drives, mi.Slots, mi.Mboxes} {
+		for id, s := range info {
+			if a, err := m.Address(s.Type, s.ID); err == nil {
+				s.Address = a
+				info[id] = s
+			}
+		}
+	}
+}
+
+// elementMapOf builds an ElementMap from the status of every element
+// of a changer, as returned by ElementExecutor.Elements.  Drives and
+// storage elements are numbered in the order given, import/export
+// elements after the storage elements as mtx does.
+func elementMapOf(elems []smc.Element) ElementMap {
+	m := ElementMap{DriveAddresses: []int{}, SlotAddresses: []int{}}
+	var ie []int
+	count := func(first, num *int, addr uint16) {
+		if *num == 0 || int(addr) < *first {
+			*first = int(addr)
+		}
+		*num++
+	}
+	for _, e := range elems {
+		switch e.Type {
+		case smc.MediumTransport:
+			count(&m.FirstTransport, &m.NumTransport, e.Address)
+		case smc.DataTransfer:
+			count(&m.FirstDrive, &m.NumDrives, e.Address)
+			m.DriveAddresses = append(m.DriveAddresses, int(e.Address))
+		case smc.Storage:
+			count(&m.FirstStorage, &m.NumStorage, e.Address)
+			m.SlotAddresses = append(m.SlotAddresses, int(e.Address))
+		case smc.ImportExport:
+			count(&m.FirstImportExport, &m.NumImportExport, e.Address)
+			ie = append(ie, int(e.Address))
+		}
+	}
+	m.SlotAddresses = append(m.SlotAddresses, ie...)
+	return m
+}
+
+// FormatAddress formats an element address the way library GUIs
+// and logs usually show it, for example 0x03E8
+func FormatAddress(addr int) string {
+	return fmt.Sprintf("0x%04X", addr)
+}
+
+// errNoAddresses is returned by parseLoaderInfo for output without
+// first element addresses
+var errNoAddresses = errors.New("no element addresses in loaderinfo output, stock mtx does not print them")
+
+// parseLoaderInfo reads the element counts and first element addresses
+// from "mtx loaderinfo" output.  Stock mtx only prints the counts, the
+// addresses are printed by the sg executor and the mtxtest simulator.
+func parseLoaderInfo(r io.Reader) (ElementMap, error) {
+	var m ElementMap
+	fields := func(kind string) (first, num *int) {
+		switch kind {
+		case "Medium Transport":
+			return &m.FirstTransport, &m.NumTransport
+		case "Storage":
+			return &m.FirstStorage, &m.NumStorage
+		case "Import/Export":
+			return &m.FirstImportExport, &m.NumImportExport
+		}
+		return &m.FirstDrive, &m.NumDrives
+	}
+	found := 0
+	lscanner := bufio.NewScanner(r)
+	for lscanner.Scan() {
+		line := lscanner.Text()
+		if match := liNumRxp.FindStringSubmatch(line); match != nil {
+			_, num := fields(match[1])
+			n, err := strconv.Atoi(match[2])
+			if err != nil {
+				return ElementMap{}, err
+			}
+			*num = n
+			continue
+		}
+		if match := liFirstRxp.FindStringSubmatch(line); match != nil {
+			first, _ := fields(match[1])
+			n, err := strconv.Atoi(match[2])
+			if err != nil {
+				return ElementMap{}, err
+			}
+			*first = n
+			found++
+		}
+	}
+	if err := lscanner.Err(); err != nil {
+		return ElementMap{}, err
+	}
+	if found == 0 {
+		return ElementMap{}, errNoAddresses
+	}
+	return m, nil
+}
+
+// ElementMap reads the element address assignment from the changer.
+// If Executor or AddressExecutor is an ElementExecutor, such as an
+// sg.Executor, the address of each element is read from its status,
+// otherwise the first addresses are read with "mtx loaderinfo".  Once
+// read, later calls to Status fill in Slot.Address.  Stock mtx does
+// not print element addresses, so with the default Executor
+// ElementMap fails unless AddressExecutor is set; otherwise use
+// SetElementMap.
+func (l *Library) ElementMap() (*ElementMap, error) {
+	return l.ElementMapContext(context.Background())
+}
+
+// ElementMapContext is like ElementMap but gives up if ctx is done
+// before the Library lock is acquired or before mtx completes
+func (l *Library) ElementMapContext(ctx context.Context) (*ElementMap, error) {
+	if err := l.lock(ctx); err != nil {
+		return nil, errors.Wrap(err, "loaderinfo")
+	}
+	defer l.unlock()
+	ee, ok := l.executor().(ElementExecutor)
+	if !ok {
+		ee, ok = l.AddressExecutor.(ElementExecutor)
+	}
+	if ok {
+		elems, err := ee.Elements(ctx, l.Device)
+		if err != nil {
+			return nil, errors.Wrap(err, "element status")
+		}
+		m := elementMapOf(elems)
+		l.update(func() {
+			l.em = &m
+			if l.initialized {
+				m.assign(&l.mi)
+			}
+		})
+		return &m, nil
+	}
+	m, err := l.loaderInfo(ctx, l.executor())
+	if err == errNoAddresses && l.AddressExecutor != nil {
+		m, err = l.loaderInfo(ctx, l.AddressExecutor)
+	}
+	if err == errNoAddresses {
+		return nil, errors.Wrap(err, "loaderinfo: set AddressExecutor or use SetElementMap")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "loaderinfo")
+	}
+	l.update(func() {
+		l.em = &m
+		if l.initialized {
+			m.assign(&l.mi)
+		}
+	})
+	return &m, nil
+}
+
+// loaderInfo runs "loaderinfo" with e and parses the output
+func (l *Library) loaderInfo(ctx context.Context, e Executor) (ElementMap, error) {
+	result, err := l.runCmd(ctx, e, "loaderinfo")
+	if err != nil {
+		return ElementMap{}, err
+	}
+	return parseLoaderInfo(bytes.NewReader(result))
+}
+
+// SetElementMap sets the element address assignment used to fill in
+// Slot.Address, for changers where it is known from configuration,
+// such as with stock mtx when no AddressExecutor is available
+func (l *Library) SetElementMap(m ElementMap) {
+	l.lock(context.Background())
+	defer l.unlock()
+	l.update(func() {
+		l.em = &m
+		if l.initialized {
+			m.assign(&l.mi)
+		}
+	})
+}
+
+// Geometry describes how a vendor GUI numbers storage slots by
+// magazine, column and row.  All GUI numbers start at 1.
+type Geometry struct {
+	// Columns and Rows are the size of each magazine
+	Columns, Rows int
+	// RowMajor numbers slots across a row before moving to the
+	// next row, otherwise down a column first
+	RowMajor bool
+}
+
+// Location is a storage slot position as shown by a vendor GUI
+type Location struct {
+	Magazine, Column, Row int
+}
+
+func (loc Location) String() string {
+	return fmt.Sprintf("magazine %d column %d row %d", loc.Magazine, loc.Column, loc.Row)
+}
+
+// Location returns the GUI location of the storage slot with mtx
+// element number id
+func (g Geometry) Location(id string) (Location, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 {
+		return Location{}, errors.Errorf("invalid storage element id %q", id)
+	}
+	if g.Columns < 1 || g.Rows < 1 {
+		return Location{}, errors.New("invalid geometry")
+	}
+	k := n - 1
+	per := g.Columns * g.Rows
+	loc := Location{Magazine: k/per + 1}
+	k %= per
+	if g.RowMajor {
+		loc.Row, loc.Column = k/g.Columns+1, k%g.Columns+1
+	} else {
+		loc.Column, loc.Row = k/g.Rows+1, k%g.Rows+1
+	}
+	return loc, nil
+}
+
+// ID returns the mtx storage element number of a GUI location
+func (g Geometry) ID(loc Location) (string, error) {
+	if g.Columns < 1 || g.Rows < 1 {
+		return "", errors.New("invalid geometry")
+	}
+	if loc.Magazine < 1 || loc.Column < 1 || loc.Column > g.Columns ||
+		loc.Row < 1 || loc.Row > g.Rows {
+		return "", errors.Errorf("invalid location %v", loc)
+	}
+	k := (loc.Magazine - 1) * g.Columns * g.Rows
+	if g.RowMajor {
+		k += (loc.Row-1)*g.Columns + loc.Column - 1
+	} else {
+		k += (loc.Column-1)*g.Rows + loc.Row - 1
+	}
+	return strconv.Itoa(k + 1), nil
+}
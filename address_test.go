@@ -0,0 +1,252 @@
+package mtx
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benmcclelland/mtx/scsi/smc"
+)
+
+const mockLoaderInfo = `Product Type: Medium Changer
+Vendor ID: 'MTXTEST '
+Product ID: 'Simulated Changer'
+Revision: '1.00'
+Attached Changer API: No
+EAAP: Yes
+Number of Medium Transport Elements: 1
+Number of Storage Elements: 4
+Number of Import/Export Element Elements: 2
+Number of Data Transfer Elements: 2
+First Medium Transport Element Address: 0
+First Storage Element Address: 1000
+First Import/Export Element Address: 10
+First Data Transfer Element Address: 500
+`
+
+func TestParseLoaderInfo(t *testing.T) {
+	m, err := parseLoaderInfo(strings.NewReader(mockLoaderInfo))
+	if err != nil {
+		t.Fatalf("parseLoaderInfo(): %v", err)
+	}
+	want := ElementMap{
+		FirstTransport: 0, NumTransport: 1,
+		FirstStorage: 1000, NumStorage: 4,
+		FirstImportExport: 10, NumImportExport: 2,
+		FirstDrive: 500, NumDrives: 2,
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("parseLoaderInfo(): expected %+v, got %+v", want, m)
+	}
+	// stock mtx only reports element counts
+	stock := strings.Split(mockLoaderInfo, "First Medium")[0]
+	if _, err := parseLoaderInfo(strings.NewReader(stock)); err == nil {
+		t.Errorf("parseLoaderInfo(): expected error without addresses")
+	}
+}
+
+func TestElementMap(t *testing.T) {
+	m := ElementMap{
+		FirstStorage: 1000, NumStorage: 4,
+		FirstImportExport: 10, NumImportExport: 2,
+		FirstDrive: 500, NumDrives: 2,
+	}
+	tests := []struct {
+		t    SlotType
+		id   string
+		addr int
+	}{
+		{DataTransferElement, "0", 500},
+		{DataTransferElement, "1", 501},
+		{StorageElement, "1", 1000},
+		{StorageElement, "4", 1003},
+		{ImportExport, "5", 10},
+		{ImportExport, "6", 11},
+	}
+	for _, tt := range tests {
+		addr, err := m.Address(tt.t, tt.id)
+		if err != nil || addr != tt.addr {
+			t.Errorf("Address(%v, %v): expected %v, got %v %v", tt.t, tt.id, tt.addr, addr, err)
+		}
+		typ, id, err := m.ID(tt.addr)
+		if err != nil || typ != tt.t || id != tt.id {
+			t.Errorf("ID(%v): expected %v %v, got %v %v %v", tt.addr, tt.t, tt.id, typ, id, err)
+		}
+	}
+	if _, err := m.Address(StorageElement, "5"); err == nil {
+		t.Errorf("Address(storage, 5): expected error")
+	}
+	if _, _, err := m.ID(2000); err == nil {
+		t.Errorf("ID(2000): expected error")
+	}
+	if FormatAddress(1000) != "0x03E8" {
+		t.Errorf("FormatAddress(1000): expected 0x03E8, got %v", FormatAddress(1000))
+	}
+}
+
+// elemExecutor is an Executor that reports the status of each element
+type elemExecutor struct {
+	Executor
+	elems []smc.Element
+}
+
+func (e *elemExecutor) Elements(ctx context.Context, device string) ([]smc.Element, error) {
+	return e.elems, nil
+}
+
+func TestLibraryElementMapGaps(t *testing.T) {
+	// storage addresses skip 1002 and 1003, import/export elements
+	// follow the storage elements in mtx numbering
+	elems := []smc.Element{
+		{Type: smc.MediumTransport, Address: 0},
+		{Type: smc.DataTransfer, Address: 500},
+		{Type: smc.DataTransfer, Address: 502},
+		{Type: smc.Storage, Address: 1000},
+		{Type: smc.Storage, Address: 1001},
+		{Type: smc.Storage, Address: 1004},
+		{Type: smc.Storage, Address: 1005},
+		{Type: smc.ImportExport, Address: 10},
+		{Type: smc.ImportExport, Address: 11},
+	}
+	lib := NewLibraryExecutor("/dev/sga", &elemExecutor{
+		Executor: NewScriptedExecutor(Step{Args: []string{"status"}, Stdout: mockStatus}),
+		elems:    elems,
+	})
+	m, err := lib.ElementMap()
+	if err != nil {
+		t.Fatalf("ElementMap(): %v", err)
+	}
+	want := ElementMap{
+		FirstTransport: 0, NumTransport: 1,
+		FirstStorage: 1000, NumStorage: 4,
+		FirstImportExport: 10, NumImportExport: 2,
+		FirstDrive: 500, NumDrives: 2,
+		DriveAddresses: []int{500, 502},
+		SlotAddresses:  []int{1000, 1001, 1004, 1005, 10, 11},
+	}
+	if !reflect.DeepEqual(*m, want) {
+		t.Fatalf("ElementMap(): expected %+v, got %+v", want, *m)
+	}
+	tests := []struct {
+		t    SlotType
+		id   string
+		addr int
+	}{
+		{DataTransferElement, "1", 502},
+		{StorageElement, "2", 1001},
+		{StorageElement, "3", 1004},
+		{ImportExport, "6", 11},
+	}
+	for _, tt := range tests {
+		addr, err := m.Address(tt.t, tt.id)
+		if err != nil || addr != tt.addr {
+			t.Errorf("Address(%v, %v): expected %v, got %v %v", tt.t, tt.id, tt.addr, addr, err)
+		}
+		typ, id, err := m.ID(tt.addr)
+		if err != nil || typ != tt.t || id != tt.id {
+			t.Errorf("ID(%v): expected %v %v, got %v %v %v", tt.addr, tt.t, tt.id, typ, id, err)
+		}
+	}
+	for _, addr := range []int{501, 1002} {
+		if _, _, err := m.ID(addr); err == nil {
+			t.Errorf("ID(%v): expected error for address in a gap", addr)
+		}
+	}
+	if _, err := m.Address(ImportExport, "4"); err == nil {
+		t.Errorf("Address(mailbox, 4): expected error for a storage element number")
+	}
+	mi, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if mi.Slots["3"].Address != 1004 || mi.Drives["1"].Address != 502 {
+		t.Errorf("Status(): unexpected addresses slot 3 %v drive 1 %v",
+			mi.Slots["3"].Address, mi.Drives["1"].Address)
+	}
+}
+
+func TestLibraryElementMap(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"loaderinfo"}, Stdout: mockLoaderInfo},
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+	))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if m.Slots["3"].Address != -1 {
+		t.Errorf("Status(): expected unknown address, got %v", m.Slots["3"].Address)
+	}
+	if _, err := lib.ElementMap(); err != nil {
+		t.Fatalf("ElementMap(): %v", err)
+	}
+	if m.Slots["3"].Address != -1 {
+		t.Errorf("ElementMap(): expected snapshot unchanged, got address %v", m.Slots["3"].Address)
+	}
+	if c, ok := lib.Cached(); !ok || c.Slots["3"].Address != 1002 {
+		t.Errorf("ElementMap(): expected cached slot 3 address 1002, got %v", c.Slots["3"].Address)
+	}
+	m, err = lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if m.Drives["1"].Address != 501 || m.Mboxes["5"].Address != 10 {
+		t.Errorf("Status(): unexpected addresses drive 1 %v mbox 5 %v",
+			m.Drives["1"].Address, m.Mboxes["5"].Address)
+	}
+}
+
+func TestLibraryElementMapStock(t *testing.T) {
+	stock := strings.Split(mockLoaderInfo, "First Medium")[0]
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"loaderinfo"}, Stdout: stock},
+	))
+	_, err := lib.ElementMap()
+	if err == nil || !strings.Contains(err.Error(), "SetElementMap") {
+		t.Errorf("ElementMap(): expected error pointing to SetElementMap, got %v", err)
+	}
+
+	// addresses come from AddressExecutor when mtx does not print them
+	lib = NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"loaderinfo"}, Stdout: stock},
+	))
+	lib.AddressExecutor = NewScriptedExecutor(
+		Step{Args: []string{"loaderinfo"}, Stdout: mockLoaderInfo},
+	)
+	m, err := lib.ElementMap()
+	if err != nil {
+		t.Fatalf("ElementMap(): %v", err)
+	}
+	if m.FirstStorage != 1000 || m.FirstDrive != 500 {
+		t.Errorf("ElementMap(): expected addresses from AddressExecutor, got %+v", *m)
+	}
+}
+
+func TestGeometry(t *testing.T) {
+	tests := []struct {
+		g   Geometry
+		id  string
+		loc Location
+	}{
+		{Geometry{Columns: 2, Rows: 5}, "1", Location{1, 1, 1}},
+		{Geometry{Columns: 2, Rows: 5}, "6", Location{1, 2, 1}},
+		{Geometry{Columns: 2, Rows: 5}, "12", Location{2, 1, 2}},
+		{Geometry{Columns: 2, Rows: 5, RowMajor: true}, "6", Location{1, 2, 3}},
+		{Geometry{Columns: 2, Rows: 5, RowMajor: true}, "11", Location{2, 1, 1}},
+	}
+	for _, tt := range tests {
+		loc, err := tt.g.Location(tt.id)
+		if err != nil || loc != tt.loc {
+			t.Errorf("%+v Location(%v): expected %v, got %v %v", tt.g, tt.id, tt.loc, loc, err)
+		}
+		id, err := tt.g.ID(tt.loc)
+		if err != nil || id != tt.id {
+			t.Errorf("%+v ID(%v): expected %v, got %v %v", tt.g, tt.loc, tt.id, id, err)
+		}
+	}
+	if _, err := (Geometry{Columns: 2, Rows: 5}).ID(Location{1, 3, 1}); err == nil {
+		t.Errorf("ID(): expected error for column out of range")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// flock is not supported, so concurrent mtxsim processes may lose
+// state updates
+func flock(f *os.File) error {
+	return nil
+}
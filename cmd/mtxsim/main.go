@@ -0,0 +1,187 @@
+/*
+Command mtxsim is a stand-in for the mtx executable backed by the
+mtxtest simulated changer.  It accepts the same command line as mtx:
+
+	mtxsim [-f <device>] status|inventory|eject|first|next|
+		load <slot> [<drive>]|unload [<slot>] [<drive>]|
+		transfer <slot> <slot>|exchange <slot> <slot> [<slot>]
+
+The state of every simulated device is kept in a JSON file keyed by
+device path, $MTXSIM_STATE or mtxsim.json in the temp directory by
+default.  A device not yet in the file starts out with 2 drives,
+4 storage slots and 2 import/export slots.  Edit the file to set up
+other layouts or to add mtxtest.Fault rules, for example:
+
+	"faults": [{"command": "load", "args": ["*", "1"], "error": "Drive 1 door open"}]
+
+Concurrent mtxsim processes are serialized per device, and updates of
+the state file are serialized with flock on lock files next to it.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/benmcclelland/mtx/mtxtest"
+	"github.com/pkg/errors"
+)
+
+// defaultConfig is the layout used for devices not found in the state file
+var defaultConfig = mtxtest.Config{
+	Drives:       2,
+	Slots:        4,
+	ImportExport: 2,
+	Volumes: map[int]string{
+		1: "M00001L6",
+		3: "M00003L6",
+		4: "CLN004L6",
+		5: "M00002L6",
+	},
+	Loaded: map[int]int{0: 1},
+}
+
+func main() {
+	// Injected hangs block until the process is signalled
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	rc := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(rc)
+}
+
+func statePath() string {
+	if p := os.Getenv("MTXSIM_STATE"); p != "" {
+		return p
+	}
+	return filepath.Join(os.TempDir(), "mtxsim.json")
+}
+
+// run executes one mtx command line and returns the process exit code
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	device := os.Getenv("CHANGER")
+	if len(args) >= 2 && args[0] == "-f" {
+		device = args[1]
+		args = args[2:]
+	}
+	if device == "" {
+		fmt.Fprintln(stderr, "No SCSI device specified, use -f or set CHANGER")
+		return 1
+	}
+
+	path := statePath()
+	// the device stays locked while the command runs, like a changer
+	// busy with a move, but other devices only wait for file updates
+	dlock, err := lockFile(path + "." + strings.ReplaceAll(device, "/", "_") + ".lock")
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer dlock.Close()
+
+	var states map[string]mtxtest.State
+	err = withStates(path, func() (err error) {
+		states, err = readStates(path)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	var c *mtxtest.Changer
+	if s, ok := states[device]; ok {
+		c = mtxtest.FromState(s)
+	} else {
+		c = mtxtest.New(defaultConfig)
+	}
+
+	cmderr := c.ExecContext(ctx, stdout, device, args...)
+
+	// Save even if the command failed to keep fault match counts.  The
+	// file is read again since other devices may have changed.
+	err = withStates(path, func() error {
+		states, err := readStates(path)
+		if err != nil {
+			return err
+		}
+		states[device] = c.State()
+		return writeStates(path, states)
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if cmderr != nil {
+		fmt.Fprintln(stderr, cmderr)
+		return 1
+	}
+	return 0
+}
+
+// withStates runs fn holding the lock on the state file at path
+func withStates(path string, fn func() error) error {
+	l, err := lockFile(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return fn()
+}
+
+// lockFile opens path, creating it if needed, and waits for an
+// exclusive lock on it.  Closing the file releases the lock.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, errors.Wrap(err, "lock state")
+	}
+	if err := flock(f); err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "lock state")
+	}
+	return f, nil
+}
+
+func readStates(path string) (map[string]mtxtest.State, error) {
+	states := make(map[string]mtxtest.State)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read state")
+	}
+	if err := json.Unmarshal(b, &states); err != nil {
+		return nil, errors.Wrapf(err, "parse state %v", path)
+	}
+	return states, nil
+}
+
+// writeStates replaces the state file atomically so that an interrupted
+// mtxsim never leaves a truncated file behind
+func writeStates(path string, states map[string]mtxtest.State) error {
+	b, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encode state")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".mtxsim")
+	if err != nil {
+		return errors.Wrap(err, "write state")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write state")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "write state")
+	}
+	return errors.Wrap(os.Rename(tmp.Name(), path), "write state")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/benmcclelland/mtx"
+)
+
+func setState(t *testing.T) {
+	t.Setenv("MTXSIM_STATE", filepath.Join(t.TempDir(), "state.json"))
+}
+
+func mtxsim(args ...string) (string, string, int) {
+	var stdout, stderr bytes.Buffer
+	rc := run(context.Background(), args, &stdout, &stderr)
+	return stdout.String(), stderr.String(), rc
+}
+
+func TestStatePersists(t *testing.T) {
+	setState(t)
+	_, stderr, rc := mtxsim("-f", "/dev/sga", "load", "3", "1")
+	if rc != 0 {
+		t.Fatalf("load: exit %v: %v", rc, stderr)
+	}
+	out, _, rc := mtxsim("-f", "/dev/sga", "status")
+	if rc != 0 {
+		t.Fatalf("status: exit %v", rc)
+	}
+	if !strings.Contains(out, "Data Transfer Element 1:Full (Storage Element 3 Loaded):VolumeTag = M00003L6\n") {
+		t.Errorf("status: expected drive 1 loaded from slot 3, got\n%s", out)
+	}
+	if !strings.Contains(out, "      Storage Element 3:Empty\n") {
+		t.Errorf("status: expected slot 3 empty, got\n%s", out)
+	}
+
+	// other devices are independent
+	out, _, _ = mtxsim("-f", "/dev/sgb", "status")
+	if !strings.Contains(out, "Data Transfer Element 1:Empty\n") {
+		t.Errorf("status: expected /dev/sgb drive 1 empty, got\n%s", out)
+	}
+}
+
+func TestErrorExit(t *testing.T) {
+	setState(t)
+	_, stderr, rc := mtxsim("-f", "/dev/sga", "load", "2", "1")
+	if rc != 1 {
+		t.Errorf("load: expected exit 1, got %v", rc)
+	}
+	if stderr != "Storage Element 2 is Empty\n" {
+		t.Errorf("load: unexpected stderr %q", stderr)
+	}
+}
+
+func TestFirstNext(t *testing.T) {
+	setState(t)
+	_, stderr, rc := mtxsim("-f", "/dev/sga", "first", "1")
+	if rc != 0 {
+		t.Fatalf("first: exit %v: %v", rc, stderr)
+	}
+	out, stderr, rc := mtxsim("-f", "/dev/sga", "next", "1")
+	if rc != 0 {
+		t.Fatalf("next: exit %v: %v", rc, stderr)
+	}
+	want := "Unloading drive 1 into Storage Element 3...done\n" +
+		"Loading media from Storage Element 4 into drive 1...done\n"
+	if out != want {
+		t.Errorf("next: expected %q, got %q", want, out)
+	}
+	_, stderr, rc = mtxsim("-f", "/dev/sga", "next", "1")
+	if rc != 1 || stderr != "No More Media\n" {
+		t.Errorf("next: expected \"No More Media\" exit 1, got %q exit %v", stderr, rc)
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping mtxsim build in short mode")
+	}
+	setState(t)
+	bin := filepath.Join(t.TempDir(), "mtxsim")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Skipf("cannot build mtxsim: %v\n%s", err, out)
+	}
+	lib := mtx.NewLibraryCmd("/dev/sga", bin)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Load(m.Slots["3"].Vol, m.Drives["1"]); err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+	m, err = lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if m.Drives["1"].Vol == nil || m.Drives["1"].Vol.ID != "M00003L6" {
+		t.Errorf("expected M00003L6 in drive 1, got %+v", m.Drives["1"].Vol)
+	}
+	if err := lib.Load(m.Slots["4"].Vol, m.Drives["1"]); err == nil {
+		t.Errorf("Load(): expected error loading into full drive")
+	}
+}
+
+func TestFaultPersists(t *testing.T) {
+	setState(t)
+	path := os.Getenv("MTXSIM_STATE")
+	b := []byte(`{"/dev/sga": {
+		"drives": [{}, {}],
+		"slots": [{"full": true, "tag": "A"}, {"full": true, "tag": "B"}],
+		"faults": [{"command": "load", "nth": 2, "error": "Drive 1 door open"}]
+	}}`)
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, rc := mtxsim("-f", "/dev/sga", "load", "1", "0"); rc != 0 {
+		t.Fatalf("load: exit %v: %v", rc, stderr)
+	}
+	_, stderr, rc := mtxsim("-f", "/dev/sga", "load", "2", "1")
+	if rc != 1 || stderr != "Drive 1 door open\n" {
+		t.Errorf("load: expected injected fault, got %q exit %v", stderr, rc)
+	}
+	if _, stderr, rc := mtxsim("-f", "/dev/sga", "load", "2", "1"); rc != 0 {
+		t.Errorf("load: exit %v: %v", rc, stderr)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	setState(t)
+	// transfers on one device and first use of others, all at once
+	cmds := [][]string{
+		{"-f", "/dev/sga", "transfer", "3", "2"},
+		{"-f", "/dev/sga", "transfer", "4", "1"},
+	}
+	for i := 0; i < 8; i++ {
+		cmds = append(cmds, []string{"-f", "/dev/sg" + strconv.Itoa(i), "load", "3", "1"})
+	}
+	var wg sync.WaitGroup
+	for _, args := range cmds {
+		wg.Add(1)
+		go func(args []string) {
+			defer wg.Done()
+			if _, stderr, rc := mtxsim(args...); rc != 0 {
+				t.Errorf("%v: exit %v: %v", args, rc, stderr)
+			}
+		}(args)
+	}
+	wg.Wait()
+
+	out, _, _ := mtxsim("-f", "/dev/sga", "status")
+	for _, want := range []string{"Storage Element 1:Full :VolumeTag=CLN004L6\n", "Storage Element 2:Full :VolumeTag=M00003L6\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("status /dev/sga: expected %q, got\n%s", want, out)
+		}
+	}
+	for i := 0; i < 8; i++ {
+		out, _, _ := mtxsim("-f", "/dev/sg"+strconv.Itoa(i), "status")
+		if !strings.Contains(out, "Data Transfer Element 1:Full (Storage Element 3 Loaded)") {
+			t.Errorf("status /dev/sg%v: expected drive 1 loaded, got\n%s", i, out)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package mtx
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Condition is a requirement on the cached Library state.  Operations
+// given conditions check them before running mtx, so a decision made
+// from a stale snapshot fails instead of moving the wrong media.
+type Condition struct {
+	want  string
+	check func(mi *MediaInfo) (got string, ok bool)
+}
+
+// AtGeneration requires the cached state to be at generation gen, the
+// Generation of the snapshot a decision was made from.  Any Status or
+// completed operation since the snapshot was taken is a conflict.
+func AtGeneration(gen uint64) Condition {
+	return Condition{
+		want: "generation " + strconv.FormatUint(gen, 10),
+		check: func(mi *MediaInfo) (string, bool) {
+			return "generation " + strconv.FormatUint(mi.Generation, 10), mi.Generation == gen
+		},
+	}
+}
+
+// Holding requires element id of type t to hold the volume with the
+// given primary or alternate barcode, or to be empty if barcode is "".
+// Unlike AtGeneration, changes elsewhere in the library are not
+// conflicts.
+func Holding(t SlotType, id, barcode string) Condition {
+	return Condition{
+		want: describeElement(t, id, barcode, true),
+		check: func(mi *MediaInfo) (string, bool) {
+			s, ok := mi.elements(t)[id]
+			if !ok {
+				return describeElement(t, id, "", false), false
+			}
+			if s.Vol == nil {
+				return describeElement(t, id, "", true), barcode == ""
+			}
+			return describeElement(t, id, s.Vol.name(), true), barcode != "" &&
+				(s.Vol.ID == barcode || s.Vol.AltID == barcode)
+		},
+	}
+}
+
+// describeElement describes the state of an element for errors
+func describeElement(t SlotType, id, holds string, exists bool) string {
+	switch {
+	case !exists:
+		return fmt.Sprintf("no %v %v", t, id)
+	case holds == "":
+		return fmt.Sprintf("%v %v empty", t, id)
+	}
+	return fmt.Sprintf("%v %v holding %v", t, id, holds)
+}
+
+// String returns the state the condition requires
+func (c Condition) String() string {
+	return c.want
+}
+
+// ConflictError is returned by operations when a Condition does not
+// hold for the cached Library state.  mtx is not run.
+type ConflictError struct {
+	// Op is the operation that was not run
+	Op string
+	// Want is the state required by the failed Condition
+	Want string
+	// Got is the cached state, or "no cached state" if there is no
+	// valid state to check
+	Got string
+	// Generation is the generation of the cached state
+	Generation uint64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v: conflict: expected %v, found %v", e.Op, e.Want, e.Got)
+}
+
+// checkConditions returns a *ConflictError for the first of conds that
+// does not hold.  The Library lock must be held.
+func (l *Library) checkConditions(op string, conds []Condition) error {
+	for _, c := range conds {
+		if !l.initialized {
+			return &ConflictError{Op: op, Want: c.want, Got: "no cached state", Generation: l.gen}
+		}
+		if got, ok := c.check(&l.mi); !ok {
+			return &ConflictError{Op: op, Want: c.want, Got: got, Generation: l.gen}
+		}
+	}
+	return nil
+}
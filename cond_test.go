@@ -0,0 +1,74 @@
+package mtx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestConditions(t *testing.T) {
+	rec := &RecordingExecutor{Exec: NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"load", "3", "1"}},
+		Step{Args: []string{"unload", "3", "1"}},
+	)}
+	lib := NewLibraryExecutor("/dev/sga", rec)
+	ctx := context.Background()
+
+	if err := lib.LoadIf(ctx, &Volume{ID: "M00003L6", Home: "3"}, Slot{ID: "1"}, AtGeneration(0)); err == nil {
+		t.Errorf("LoadIf(): expected conflict without status")
+	} else if cerr, ok := err.(*ConflictError); !ok || cerr.Got != "no cached state" {
+		t.Errorf("LoadIf(): expected no cached state conflict, got %v", err)
+	}
+
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	vol := m.Slots["3"].Vol
+	if err := lib.LoadIf(ctx, vol, m.Drives["1"], AtGeneration(m.Generation), Holding(StorageElement, "3", "M00003L6")); err != nil {
+		t.Fatalf("LoadIf(): %v", err)
+	}
+
+	for _, tc := range []struct {
+		cond Condition
+		want string
+	}{
+		{AtGeneration(m.Generation), "unloadvol: conflict: expected generation 1, found generation 2"},
+		{Holding(StorageElement, "3", "M00003L6"), "unloadvol: conflict: expected storage 3 holding M00003L6, found storage 3 empty"},
+		{Holding(DataTransferElement, "1", ""), "unloadvol: conflict: expected drive 1 empty, found drive 1 holding M00003L6"},
+		{Holding(DataTransferElement, "1", "M00001L6"), "unloadvol: conflict: expected drive 1 holding M00001L6, found drive 1 holding M00003L6"},
+		{Holding(ImportExport, "9", ""), "unloadvol: conflict: expected mailbox 9 empty, found no mailbox 9"},
+	} {
+		err := lib.UnloadIf(ctx, vol, tc.cond)
+		cerr, ok := errors.Cause(err).(*ConflictError)
+		if !ok {
+			t.Errorf("UnloadIf(%v): expected *ConflictError, got %v", tc.cond, err)
+			continue
+		}
+		if err.Error() != tc.want {
+			t.Errorf("UnloadIf(%v): expected %q, got %q", tc.cond, tc.want, err)
+		}
+		if cerr.Generation != 2 {
+			t.Errorf("UnloadIf(%v): expected generation 2, got %v", tc.cond, cerr.Generation)
+		}
+	}
+	if n := len(rec.Calls()); n != 2 {
+		t.Errorf("UnloadIf(): expected conflicts not to run mtx, got %v calls", n)
+	}
+
+	// a change to another element is not a conflict for Holding
+	if err := lib.UnloadIf(ctx, vol, Holding(DataTransferElement, "1", "M00003L6"), Holding(StorageElement, "3", "")); err != nil {
+		t.Errorf("UnloadIf(): %v", err)
+	}
+	if err := lib.TransferIf(ctx, vol, m.Slots["2"], AtGeneration(2)); err == nil {
+		t.Errorf("TransferIf(): expected conflict after unload")
+	}
+	if err := lib.LoadClnIf(ctx, m.Drives["1"], AtGeneration(2)); err == nil {
+		t.Errorf("LoadClnIf(): expected conflict after unload")
+	}
+	if n := len(rec.Calls()); n != 3 {
+		t.Errorf("expected 3 mtx calls, got %v", n)
+	}
+}
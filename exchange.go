@@ -0,0 +1,176 @@
+package mtx
+
+import (
+	"context"
+
+	"github.com/benmcclelland/mtx/scsi/smc"
+	"github.com/pkg/errors"
+)
+
+// ErrExchangeUnsupported is the cause of the error returned by Exchange
+// and Exchange3 when the device capabilities of the changer do not
+// allow the exchange
+var ErrExchangeUnsupported = errors.New("changer does not support exchange")
+
+// Exchange will attempt to swap the volumes in a and b with a single
+// exchange.  a and b must be full drive, storage or mailbox elements.
+// The device capabilities are read from the Executor, or else the
+// AddressExecutor, if it is a CapabilityExecutor, and an exchange they
+// do not allow fails with cause ErrExchangeUnsupported without moving
+// anything.  mtx itself cannot address drives, so without a
+// CapabilityExecutor only storage and mailbox elements can be
+// exchanged.  The home slots of the volumes are kept the same way as
+// Move.
+func (l *Library) Exchange(a, b Slot) error {
+	return l.ExchangeContext(context.Background(), a, b)
+}
+
+// ExchangeContext is like Exchange but gives up if ctx is done before
+// the Library lock is acquired.  If ctx is done while the exchange is
+// in progress, an error with cause ErrStateUnknown is returned.
+func (l *Library) ExchangeContext(ctx context.Context, a, b Slot) error {
+	return l.ExchangeIf(ctx, a, b)
+}
+
+// ExchangeIf is like ExchangeContext but first checks conds against the
+// cached state.  If one does not hold, a *ConflictError is returned
+// without running mtx.
+func (l *Library) ExchangeIf(ctx context.Context, a, b Slot, conds ...Condition) error {
+	return l.Exchange3If(ctx, a, b, a, conds...)
+}
+
+// Exchange3 will attempt to move the volume in a to b and the volume
+// that was in b to c with a single mtx exchange.  c must be empty, or
+// a for a simple swap.  The element rules are the same as Exchange.
+func (l *Library) Exchange3(a, b, c Slot) error {
+	return l.Exchange3Context(context.Background(), a, b, c)
+}
+
+// Exchange3Context is like Exchange3 but gives up if ctx is done before
+// the Library lock is acquired.  If ctx is done while the exchange is
+// in progress, an error with cause ErrStateUnknown is returned.
+func (l *Library) Exchange3Context(ctx context.Context, a, b, c Slot) error {
+	return l.Exchange3If(ctx, a, b, c)
+}
+
+// Exchange3If is like Exchange3Context but first checks conds against
+// the cached state.  If one does not hold, a *ConflictError is returned
+// without running mtx.
+func (l *Library) Exchange3If(ctx context.Context, a, b, c Slot, conds ...Condition) error {
+	if err := l.lock(ctx); err != nil {
+		return errors.Wrap(err, "exchange")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("exchange", conds); err != nil {
+		return err
+	}
+	return errors.Wrap(l.exchange(ctx, a, b, c), "exchange")
+}
+
+// exchange moves the volume in a to b and the volume in b to c, checking
+// the elements against the cached state if there is one, and updates
+// the cache.  The Library lock must be held.
+func (l *Library) exchange(ctx context.Context, a, b, c Slot) error {
+	swap := a.Type == c.Type && a.ID == c.ID
+	for _, s := range []*Slot{&a, &b, &c} {
+		if s.Type != DataTransferElement && s.Type != StorageElement && s.Type != ImportExport {
+			return errors.Errorf("invalid element type %v for element %v", s.Type, s.ID)
+		}
+		if !l.initialized {
+			continue
+		}
+		cached, ok := l.mi.elements(s.Type)[s.ID]
+		if !ok {
+			return errors.Errorf("no %v element %v", s.Type, s.ID)
+		}
+		*s = cached
+	}
+	switch {
+	case a.Type == b.Type && a.ID == b.ID, b.Type == c.Type && b.ID == c.ID:
+		return errors.Errorf("cannot exchange %v element %v with itself", b.Type, b.ID)
+	case l.initialized && a.Vol == nil:
+		return errors.Errorf("%v element %v is empty", a.Type, a.ID)
+	case l.initialized && b.Vol == nil:
+		return errors.Errorf("%v element %v is empty", b.Type, b.ID)
+	case !swap && c.Vol != nil:
+		return errors.Errorf("%v element %v is not empty, holding %v", c.Type, c.ID, c.Vol.name())
+	}
+
+	if _, native := l.executor().(CapabilityExecutor); !native {
+		for _, s := range []Slot{a, b, c} {
+			if s.Type == DataTransferElement {
+				return errors.Errorf("drive element %v, mtx can only exchange storage and mailbox elements", s.ID)
+			}
+		}
+	}
+	caps, err := l.capabilities(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range [][2]Slot{{a, b}, {b, c}} {
+		if caps != nil && !caps.Exchange[p[0].Type.smc()][p[1].Type.smc()] {
+			return errors.Wrapf(ErrExchangeUnsupported, "%v to %v", p[0].Type, p[1].Type)
+		}
+	}
+
+	args := []string{"exchange", exchangeArg(a), exchangeArg(b)}
+	if !swap {
+		args = append(args, exchangeArg(c))
+	}
+	err = l.move(ctx, args...)
+	if err == nil && l.initialized {
+		l.update(func() {
+			va, vb := l.placed(*a.Vol, a, b), l.placed(*b.Vol, b, c)
+			// a is emptied first so each barcode is indexed in one
+			// element only
+			l.mi.setSlot(Slot{Type: a.Type, ID: a.ID, Address: a.Address})
+			l.mi.setSlot(Slot{Type: b.Type, ID: b.ID, Address: b.Address, Vol: &va})
+			l.mi.setSlot(Slot{Type: c.Type, ID: c.ID, Address: c.Address, Vol: &vb})
+		})
+	}
+	return err
+}
+
+// exchangeArg returns the exchange argument for s, with drives written
+// as D followed by the drive number
+func exchangeArg(s Slot) string {
+	if s.Type == DataTransferElement {
+		return "D" + s.ID
+	}
+	return s.ID
+}
+
+// smc returns the SMC element type for t
+func (t SlotType) smc() smc.ElementType {
+	switch t {
+	case DataTransferElement:
+		return smc.DataTransfer
+	case StorageElement:
+		return smc.Storage
+	case ImportExport:
+		return smc.ImportExport
+	}
+	return smc.AllElements
+}
+
+// capabilities returns the changer capabilities from the Executor or
+// AddressExecutor, or nil if neither can read them.  The Library lock
+// must be held.
+func (l *Library) capabilities(ctx context.Context) (*smc.Capabilities, error) {
+	if l.caps != nil {
+		return l.caps, nil
+	}
+	ce, ok := l.executor().(CapabilityExecutor)
+	if !ok {
+		if ce, ok = l.AddressExecutor.(CapabilityExecutor); !ok {
+			return nil, nil
+		}
+	}
+	caps, err := ce.Capabilities(ctx, l.Device)
+	if err != nil {
+		return nil, errors.Wrap(err, "device capabilities")
+	}
+	l.caps = &caps
+	return l.caps, nil
+}
@@ -0,0 +1,217 @@
+package mtx
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/benmcclelland/mtx/scsi/smc"
+	"github.com/pkg/errors"
+)
+
+func TestExchange(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"exchange", "3", "5"}},
+		Step{Args: []string{"exchange", "4", "3", "6"}},
+	))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	check := func(s Slot, barcode, home string) {
+		t.Helper()
+		if v := s.Vol; v == nil || v.ID != barcode || v.Home != home || v.Location != s.ID || v.Drive != "" {
+			t.Errorf("%v %v: expected %v home %v, got %+v", s.Type, s.ID, barcode, home, v)
+		}
+	}
+
+	if err := lib.Exchange(m.Slots["3"], m.Mboxes["5"]); err != nil {
+		t.Fatalf("Exchange(): %v", err)
+	}
+	c, _ := lib.Cached()
+	check(c.Mboxes["5"], "M00003L6", "3")
+	check(c.Slots["3"], "M00002L6", "3")
+
+	// 4 -> 3 and 3 -> 6
+	if err := lib.Exchange3(m.Slots["4"], m.Slots["3"], m.Mboxes["6"]); err != nil {
+		t.Fatalf("Exchange3(): %v", err)
+	}
+	c, _ = lib.Cached()
+	check(c.Slots["3"], "CLN004L6", "4")
+	check(c.Mboxes["6"], "M00002L6", "3")
+	if c.Slots["4"].Vol != nil {
+		t.Errorf("Exchange3(): expected storage 4 empty, got %+v", c.Slots["4"].Vol)
+	}
+
+	for _, tc := range []struct {
+		a, b, c Slot
+		want    string
+	}{
+		{m.Drives["0"], m.Slots["3"], m.Drives["0"], "exchange: drive element 0, mtx can only exchange storage and mailbox elements"},
+		{m.Slots["3"], m.Slots["3"], m.Slots["3"], "exchange: cannot exchange storage element 3 with itself"},
+		{m.Slots["4"], m.Slots["3"], m.Slots["4"], "exchange: storage element 4 is empty"},
+		{m.Slots["3"], m.Mboxes["5"], m.Mboxes["6"], "exchange: mailbox element 6 is not empty, holding M00002L6"},
+	} {
+		if err := lib.Exchange3(tc.a, tc.b, tc.c); err == nil || err.Error() != tc.want {
+			t.Errorf("Exchange3(%v, %v, %v): expected %q, got %v", tc.a.ID, tc.b.ID, tc.c.ID, tc.want, err)
+		}
+	}
+}
+
+func TestExchangeIf(t *testing.T) {
+	rec := &RecordingExecutor{Exec: NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"exchange", "3", "5", "6"}},
+	)}
+	lib := NewLibraryExecutor("/dev/sga", rec)
+	ctx := context.Background()
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	want := "exchange: conflict: expected storage 3 holding M00001L6, found storage 3 holding M00003L6"
+	if err := lib.ExchangeIf(ctx, m.Slots["3"], m.Mboxes["5"], Holding(StorageElement, "3", "M00001L6")); err == nil || err.Error() != want {
+		t.Errorf("ExchangeIf(): expected %q, got %v", want, err)
+	}
+	err = lib.Exchange3If(ctx, m.Slots["3"], m.Mboxes["5"], m.Mboxes["6"], AtGeneration(m.Generation+1))
+	if _, ok := err.(*ConflictError); !ok {
+		t.Errorf("Exchange3If(): expected *ConflictError, got %v", err)
+	}
+	if n := len(rec.Calls()); n != 1 {
+		t.Errorf("Exchange3If(): expected conflicts not to run mtx, got %v calls", n)
+	}
+	if err := lib.Exchange3If(ctx, m.Slots["3"], m.Mboxes["5"], m.Mboxes["6"], AtGeneration(m.Generation), Holding(ImportExport, "6", "")); err != nil {
+		t.Errorf("Exchange3If(): %v", err)
+	}
+}
+
+// capsExecutor is an Executor that reports device capabilities
+type capsExecutor struct {
+	Executor
+	caps smc.Capabilities
+}
+
+func (e *capsExecutor) Capabilities(ctx context.Context, device string) (smc.Capabilities, error) {
+	return e.caps, nil
+}
+
+// exchangeCaps allows exchanges between the given element types
+func exchangeCaps(types ...smc.ElementType) smc.Capabilities {
+	var c smc.Capabilities
+	for _, s := range types {
+		for _, d := range types {
+			c.Exchange[s][d] = true
+		}
+	}
+	return c
+}
+
+func TestExchangeDrive(t *testing.T) {
+	rec := &RecordingExecutor{Exec: NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"exchange", "D0", "3"}},
+		Step{Args: []string{"exchange", "3", "D0", "2"}},
+	)}
+	lib := NewLibraryExecutor("/dev/sga", &capsExecutor{
+		Executor: rec,
+		caps:     exchangeCaps(smc.Storage, smc.ImportExport, smc.DataTransfer),
+	})
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+
+	// the tape in drive 0 swaps with the one in slot 3
+	if err := lib.Exchange(m.Drives["0"], m.Slots["3"]); err != nil {
+		t.Fatalf("Exchange(): %v", err)
+	}
+	c, _ := lib.Cached()
+	if v := c.Slots["3"].Vol; v == nil || v.ID != "M00001L6" || v.Location != "3" || v.Drive != "" || v.Home != "1" {
+		t.Errorf("Exchange(): expected M00001L6 in slot 3 with home 1, got %+v", v)
+	}
+	if v := c.Drives["0"].Vol; v == nil || v.ID != "M00003L6" || v.Drive != "0" || v.Location != "" || v.Home != "3" {
+		t.Errorf("Exchange(): expected M00003L6 in drive 0 with home 3, got %+v", v)
+	}
+	for _, want := range []struct {
+		barcode string
+		ref     elementRef
+	}{
+		{"M00001L6", elementRef{StorageElement, "3"}},
+		{"M00003L6", elementRef{DataTransferElement, "0"}},
+	} {
+		if ref := c.index[want.barcode]; ref != want.ref {
+			t.Errorf("Exchange(): expected %v indexed at %v, got %v", want.barcode, want.ref, ref)
+		}
+		if s, err := c.Locate(want.barcode); err != nil || s.Type != want.ref.typ || s.ID != want.ref.id {
+			t.Errorf("Locate(%v): expected %v %v, got %v %v %v", want.barcode, want.ref.typ, want.ref.id, s.Type, s.ID, err)
+		}
+	}
+
+	// 3 -> drive 0 and drive 0 -> 2, the tape from the drive keeps its home
+	if err := lib.Exchange3(c.Slots["3"], c.Drives["0"], c.Slots["2"]); err != nil {
+		t.Fatalf("Exchange3(): %v", err)
+	}
+	c, _ = lib.Cached()
+	if v := c.Drives["0"].Vol; v == nil || v.ID != "M00001L6" || v.Drive != "0" || v.Home != "1" {
+		t.Errorf("Exchange3(): expected M00001L6 in drive 0 with home 1, got %+v", v)
+	}
+	if v := c.Slots["2"].Vol; v == nil || v.ID != "M00003L6" || v.Location != "2" || v.Drive != "" || v.Home != "3" {
+		t.Errorf("Exchange3(): expected M00003L6 in slot 2 with home 3, got %+v", v)
+	}
+	if c.Slots["3"].Vol != nil {
+		t.Errorf("Exchange3(): expected slot 3 empty, got %+v", c.Slots["3"].Vol)
+	}
+	if n := len(rec.Calls()); n != 3 {
+		t.Errorf("expected 3 calls, got %v", n)
+	}
+}
+
+func TestExchangeUnsupported(t *testing.T) {
+	rec := &RecordingExecutor{Exec: NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+	)}
+	lib := NewLibraryExecutor("/dev/sga", &capsExecutor{
+		Executor: rec,
+		caps:     exchangeCaps(smc.Storage, smc.ImportExport),
+	})
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	err = lib.Exchange(m.Drives["0"], m.Slots["3"])
+	if errors.Cause(err) != ErrExchangeUnsupported {
+		t.Errorf("Exchange(): expected ErrExchangeUnsupported, got %v", err)
+	}
+	if n := len(rec.Calls()); n != 1 {
+		t.Errorf("Exchange(): expected no exchange to run, got %v calls", n)
+	}
+	if c, ok := lib.Cached(); !ok || c.Slots["3"].Vol.ID != "M00003L6" {
+		t.Errorf("Exchange(): expected cache unchanged")
+	}
+
+	// stock mtx is gated by the capabilities from AddressExecutor
+	lib = NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+	))
+	lib.AddressExecutor = &capsExecutor{Executor: NewScriptedExecutor()}
+	if m, err = lib.Status(); err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Exchange(m.Slots["3"], m.Slots["4"]); errors.Cause(err) != ErrExchangeUnsupported {
+		t.Errorf("Exchange(): expected ErrExchangeUnsupported, got %v", err)
+	}
+	want := "exchange: drive element 0, mtx can only exchange storage and mailbox elements"
+	if err := lib.Exchange(m.Drives["0"], m.Slots["3"]); err == nil || err.Error() != want {
+		t.Errorf("Exchange(): expected %q, got %v", want, err)
+	}
+
+	// without capabilities the mtx error is passed through
+	lib = NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"exchange", "3", "4"}, Stderr: "Illegal Request (asc 0x20 ascq 0x00): invalid command operation code\n", Err: errors.New("exit status 1")},
+	))
+	err = lib.Exchange(Slot{Type: StorageElement, ID: "3"}, Slot{Type: StorageElement, ID: "4"})
+	if err == nil || !strings.Contains(err.Error(), "asc 0x20") {
+		t.Errorf("Exchange(): expected mtx error, got %v", err)
+	}
+}
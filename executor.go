@@ -0,0 +1,214 @@
+package mtx
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benmcclelland/mtx/scsi/smc"
+	"github.com/pkg/errors"
+)
+
+// Executor runs mtx subcommands against a media changer device.
+// Implementations return the raw stdout and stderr of the command
+// along with a non-nil error if the command failed.
+type Executor interface {
+	Run(ctx context.Context, device string, args ...string) (stdout, stderr []byte, err error)
+}
+
+// CapabilityExecutor is an Executor that can also read the device
+// capabilities of the changer, such as sg.Executor.  Library checks an
+// exchange against them before running it, and exchanges media with a
+// drive only through a CapabilityExecutor, since mtx cannot address
+// drives in an exchange.  Drives are passed to exchange as D followed
+// by the drive number.
+type CapabilityExecutor interface {
+	Executor
+	Capabilities(ctx context.Context, device string) (smc.Capabilities, error)
+}
+
+// ElementExecutor is an Executor that can also read the status of
+// every element of the changer, such as sg.Executor.  Library uses it
+// for ElementMap, so that element addresses need not be consecutive.
+// Elements returns the medium transport elements, the drives in mtx
+// drive number order, then the storage and import/export elements in
+// mtx element number order.
+type ElementExecutor interface {
+	Executor
+	Elements(ctx context.Context, device string) ([]smc.Element, error)
+}
+
+// StartError is returned by an Executor when a command could not be
+// started at all, for example because the mtx executable is missing,
+// so the changer was not touched
+type StartError struct {
+	Err error
+}
+
+func (e *StartError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the error that stopped the command from starting
+func (e *StartError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the error that stopped the command from starting
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// NotStarted reports that the command was not started.  Library treats
+// any Executor error with a NotStarted method returning true the same
+// way, so Executors in other packages need not import this one.
+func (e *StartError) NotStarted() bool {
+	return true
+}
+
+// notStarted reports whether err comes from a command that was never
+// started
+func notStarted(err error) bool {
+	var ns interface{ NotStarted() bool }
+	return errors.As(err, &ns) && ns.NotStarted()
+}
+
+// DefaultWaitDelay is the CmdExecutor WaitDelay used if none is set
+const DefaultWaitDelay = time.Second
+
+// CmdExecutor runs a local mtx executable
+type CmdExecutor struct {
+	// Command is the mtx executable to run
+	Command string
+	// WaitDelay bounds how long Run waits after ctx is done for the
+	// output of Command to close, which a wrapper script may leave
+	// open in a child process.  DefaultWaitDelay is used if zero.
+	WaitDelay time.Duration
+}
+
+// Run executes "Command -f device args..." and collects its output.
+// The process is killed if ctx is done before it exits.
+func (c *CmdExecutor) Run(ctx context.Context, device string, args ...string) ([]byte, []byte, error) {
+	cmdargs := append([]string{"-f", device}, args...)
+	cmd := exec.CommandContext(ctx, c.Command, cmdargs...)
+	cmd.WaitDelay = c.WaitDelay
+	if cmd.WaitDelay == 0 {
+		cmd.WaitDelay = DefaultWaitDelay
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, nil, &StartError{Err: errors.Wrap(err, "mtx start command")}
+	}
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return stdout.Bytes(), stderr.Bytes(), errors.Wrap(err, "mtx wait command")
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
+
+// ExecFunc adapts an ordinary function to the Executor interface,
+// which is handy for in-memory fakes in tests
+type ExecFunc func(ctx context.Context, device string, args ...string) ([]byte, []byte, error)
+
+// Run calls f(ctx, device, args...)
+func (f ExecFunc) Run(ctx context.Context, device string, args ...string) ([]byte, []byte, error) {
+	return f(ctx, device, args...)
+}
+
+// Call is a single recorded Executor invocation
+type Call struct {
+	Device string
+	Args   []string
+	Stdout []byte
+	Stderr []byte
+	Err    error
+}
+
+// String representation for a Call is the mtx command line
+func (c Call) String() string {
+	return strings.Join(append([]string{"-f", c.Device}, c.Args...), " ")
+}
+
+// RecordingExecutor passes commands through to another Executor
+// and records every invocation and result
+type RecordingExecutor struct {
+	// Exec is the Executor commands are passed to
+	Exec Executor
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// Run passes the command to Exec and records the result
+func (r *RecordingExecutor) Run(ctx context.Context, device string, args ...string) ([]byte, []byte, error) {
+	stdout, stderr, err := r.Exec.Run(ctx, device, args...)
+	r.mu.Lock()
+	r.calls = append(r.calls, Call{
+		Device: device,
+		Args:   append([]string(nil), args...),
+		Stdout: stdout,
+		Stderr: stderr,
+		Err:    err,
+	})
+	r.mu.Unlock()
+	return stdout, stderr, err
+}
+
+// Calls returns a copy of the recorded invocations in order
+func (r *RecordingExecutor) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Step is one expected command and canned response for a ScriptedExecutor
+type Step struct {
+	// Args are the expected mtx arguments (without "-f device"),
+	// or nil to accept any command
+	Args   []string
+	Stdout string
+	Stderr string
+	Err    error
+}
+
+// ScriptedExecutor answers commands with a fixed sequence of Steps
+type ScriptedExecutor struct {
+	mu    sync.Mutex
+	steps []Step
+}
+
+// NewScriptedExecutor returns a ScriptedExecutor that replies with
+// steps in the given order
+func NewScriptedExecutor(steps ...Step) *ScriptedExecutor {
+	return &ScriptedExecutor{steps: steps}
+}
+
+// Run returns the next scripted response, or an error if the script is
+// exhausted or the command does not match the expected arguments
+func (s *ScriptedExecutor) Run(ctx context.Context, device string, args ...string) ([]byte, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.steps) == 0 {
+		return nil, nil, errors.Errorf("unexpected command %q, script exhausted", args)
+	}
+	step := s.steps[0]
+	if step.Args != nil && strings.Join(step.Args, " ") != strings.Join(args, " ") {
+		return nil, nil, errors.Errorf("unexpected command %q, expected %q", args, step.Args)
+	}
+	s.steps = s.steps[1:]
+	return []byte(step.Stdout), []byte(step.Stderr), step.Err
+}
+
+// Remaining returns the number of steps not yet consumed
+func (s *ScriptedExecutor) Remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.steps)
+}
@@ -0,0 +1,158 @@
+package mtx
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const mockStatus = `  Storage Changer /dev/sga:2 Drives, 6 Slots ( 2 Import/Export )
+Data Transfer Element 0:Full (Storage Element 1 Loaded):VolumeTag = M00001L6
+Data Transfer Element 1:Empty
+      Storage Element 1:Empty
+      Storage Element 2:Empty
+      Storage Element 3:Full :VolumeTag=M00003L6
+      Storage Element 4:Full :VolumeTag=CLN004L6
+      Storage Element 5 IMPORT/EXPORT:Full :VolumeTag=M00002L6
+      Storage Element 6 IMPORT/EXPORT:Empty
+`
+
+func TestScriptedExecutor(t *testing.T) {
+	s := NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"load", "3", "1"}},
+	)
+	rec := &RecordingExecutor{Exec: s}
+	lib := NewLibraryExecutor("/dev/sga", rec)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	err = lib.Load(m.Slots["3"].Vol, m.Drives["1"])
+	if err != nil {
+		t.Errorf("Load(): %v", err)
+	}
+	if s.Remaining() != 0 {
+		t.Errorf("expected script to be consumed, %v steps left", s.Remaining())
+	}
+	calls := rec.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 recorded calls, got %v", len(calls))
+	}
+	if calls[1].String() != "-f /dev/sga load 3 1" {
+		t.Errorf("expected \"-f /dev/sga load 3 1\", got %q", calls[1].String())
+	}
+}
+
+func TestScriptedExecutorUnexpected(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga",
+		NewScriptedExecutor(Step{Args: []string{"status"}, Stdout: mockStatus}))
+	if err := lib.Inventory(); err == nil {
+		t.Errorf("Inventory(): expected error for unscripted command, got nil")
+	}
+}
+
+func TestExecutorStderr(t *testing.T) {
+	fail := errors.New("exit status 1")
+	lib := NewLibraryExecutor("/dev/sga", ExecFunc(
+		func(ctx context.Context, device string, args ...string) ([]byte, []byte, error) {
+			return nil, []byte("command error!\n"), fail
+		}))
+	err := lib.Inventory()
+	if errors.Cause(err) != fail {
+		t.Errorf("Inventory(): expected cause %v, got %v", fail, err)
+	}
+	if !strings.Contains(err.Error(), "command error!") {
+		t.Errorf("Inventory(): expected stderr in error, got %v", err)
+	}
+}
+
+func TestCmdExecutor(t *testing.T) {
+	mock := filepath.Join(t.TempDir(), "mtxerr")
+	if err := os.WriteFile(mock, []byte("#!/bin/sh\necho \"command error!\" >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	e := &CmdExecutor{Command: mock}
+	_, stderr, err := e.Run(context.Background(), "/dev/sga", "status")
+	if err == nil {
+		t.Errorf("Run(): expected error, got success")
+	}
+	if string(stderr) != "command error!\n" {
+		t.Errorf("Run(): expected stderr \"command error!\\n\", got %q", stderr)
+	}
+}
+
+func TestCmdExecutorWaitDelay(t *testing.T) {
+	// the shell does not exec sleep, so killing it leaves the output
+	// open in the sleep process
+	mock := filepath.Join(t.TempDir(), "mtxwrapper")
+	if err := os.WriteFile(mock, []byte("#!/bin/sh\nsleep 5\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	lib := NewLibraryCmd("/dev/sga", mock)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := lib.StatusContext(ctx)
+	if d := time.Since(start); d > DefaultWaitDelay+time.Second {
+		t.Errorf("StatusContext(): expected return after timeout, took %v", d)
+	}
+	want := "status: mtx wait command: context deadline exceeded"
+	if errors.Cause(err) != context.DeadlineExceeded || err.Error() != want {
+		t.Errorf("StatusContext(): expected %q, got %v", want, err)
+	}
+}
+
+func TestMoveErrors(t *testing.T) {
+	mock := filepath.Join(t.TempDir(), "mtxerr")
+	if err := os.WriteFile(mock, []byte("#!/bin/sh\necho \"Drive 1 door open\" >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	newLib := func(command string) (*Library, *MediaInfo) {
+		lib := NewLibraryExecutor("/dev/sga", ExecFunc(func(ctx context.Context, device string, args ...string) ([]byte, []byte, error) {
+			if args[0] == "status" {
+				return []byte(mockStatus), nil, nil
+			}
+			return (&CmdExecutor{Command: command}).Run(ctx, device, args...)
+		}))
+		m, err := lib.Status()
+		if err != nil {
+			t.Fatalf("Status(): %v", err)
+		}
+		return lib, m
+	}
+
+	// a missing executable moved nothing
+	lib, m := newLib(filepath.Join(t.TempDir(), "nomtx"))
+	err := lib.Load(m.Slots["3"].Vol, m.Drives["1"])
+	var se *StartError
+	if !errors.As(err, &se) || errors.Is(err, ErrStateUnknown) {
+		t.Errorf("Load(): expected *StartError, got %v", err)
+	}
+	if _, ok := lib.Cached(); !ok {
+		t.Errorf("Load(): expected cache kept when mtx did not start")
+	}
+
+	// a failure after mtx started keeps the exit status
+	lib, m = newLib(mock)
+	err = lib.Load(m.Slots["3"].Vol, m.Drives["1"])
+	if errors.Cause(err) != ErrStateUnknown || !errors.Is(err, ErrStateUnknown) {
+		t.Errorf("Load(): expected cause ErrStateUnknown, got %v", err)
+	}
+	var ee *exec.ExitError
+	if !errors.As(err, &ee) || ee.ExitCode() != 1 {
+		t.Errorf("Load(): expected *exec.ExitError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Drive 1 door open") {
+		t.Errorf("Load(): expected mtx error in %q", err)
+	}
+	if _, ok := lib.Cached(); ok {
+		t.Errorf("Load(): expected cache invalidated")
+	}
+}
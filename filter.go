@@ -0,0 +1,487 @@
+package mtx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Filter is a compiled filter expression that selects elements and the
+// volumes in them.  An expression combines comparisons and flags with
+// and, or, not and parentheses, for example
+//
+//	type=storage and barcode~"^M0[0-9]+L8$" and not cleaning
+//	full and (id=1..20 or type=mailbox)
+//	gen>=7 and not loaded
+//
+// Comparisons are FIELD OP VALUE, where VALUE is a word or a double
+// quoted string in which \" and \\ are the only escapes:
+//
+//	type        drive, storage or mailbox (= !=)
+//	id          element number (= != < <= > >=)
+//	home        home storage slot of the volume (= != < <= > >=)
+//	drive       drive holding the volume (= != < <= > >=)
+//	gen         LTO generation of the volume from its barcode (= != < <= > >=)
+//	barcode     primary or alternate barcode (= != ~ !~), ~ matches a regexp
+//
+// The numeric fields also accept a range LOW..HIGH with = and !=.
+// A comparison with a field that does not apply, such as the barcode of
+// an empty slot, is false for every operator.
+//
+// Flags are empty, full, cleaning, labeled and loaded (the volume is
+// in a drive).
+type Filter struct {
+	expr  string
+	match func(Slot) bool
+}
+
+// FilterError is a syntax error in a filter expression
+type FilterError struct {
+	// Expr is the filter expression
+	Expr string
+	// Pos is the byte offset of the error in Expr
+	Pos int
+	// Msg describes the error
+	Msg string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("filter: column %d: %s", e.Pos+1, e.Msg)
+}
+
+// ParseFilter compiles a filter expression.  Syntax errors are
+// returned as a *FilterError.
+func ParseFilter(expr string) (*Filter, error) {
+	toks, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{expr: expr, toks: toks}
+	match, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t.pos, "unexpected %s", t)
+	}
+	return &Filter{expr: expr, match: match}, nil
+}
+
+// Match reports if the element s is selected by the filter
+func (f *Filter) Match(s Slot) bool {
+	return f.match(s)
+}
+
+// String returns the filter expression
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Select returns the elements selected by f, in the order of
+// AllElements
+func (mi *MediaInfo) Select(f *Filter) []Slot {
+	var result []Slot
+	for s := range mi.AllElements() {
+		if f.match(s) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// Where matches volumes in elements selected by f
+func (q VolumeQuery) Where(f *Filter) VolumeQuery {
+	return q.whereSlot(f.match)
+}
+
+// mediaGeneration returns the LTO generation of a barcode from its
+// media type suffix, or 0 if it is not an LTO barcode
+func mediaGeneration(barcode string) int {
+	if len(barcode) < 2 {
+		return 0
+	}
+	suffix := barcode[len(barcode)-2:]
+	switch {
+	case suffix[0] == 'L' && suffix[1] >= '1' && suffix[1] <= '9':
+		return int(suffix[1] - '0')
+	case suffix[0] == 'L' && suffix[1] >= 'T' && suffix[1] <= 'Z':
+		// WORM media, LT is LTO-3 through LZ for LTO-9
+		return int(suffix[1]-'T') + 3
+	case suffix == "M8":
+		// LTO-7 media initialized for LTO-8 drives
+		return 8
+	}
+	return 0
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	// text is the operator, word or unquoted string
+	text string
+	pos  int
+	// raw is the quoted source of a string
+	raw string
+}
+
+// offset returns the position in the expression of byte i of text,
+// allowing for the quotes and escapes of a string
+func (t token) offset(i int) int {
+	if t.kind != tokString {
+		return t.pos + i
+	}
+	j := 1
+	for ; i > 0; i-- {
+		if t.raw[j] == '\\' && (t.raw[j+1] == '"' || t.raw[j+1] == '\\') {
+			j++
+		}
+		j++
+	}
+	return t.pos + j
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// isWordByte reports if c can be part of an unquoted word
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.' || c == '/' || c == '*' || c == '+' || c == ':'
+}
+
+func lexFilter(expr string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			toks = append(toks, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			toks = append(toks, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == '"':
+			start := i
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(expr) {
+					return nil, &FilterError{Expr: expr, Pos: start, Msg: "unterminated string"}
+				}
+				if expr[i] == '"' {
+					i++
+					break
+				}
+				if expr[i] == '\\' && i+1 < len(expr) && (expr[i+1] == '"' || expr[i+1] == '\\') {
+					i++
+				}
+				b.WriteByte(expr[i])
+			}
+			toks = append(toks, token{kind: tokString, text: b.String(), pos: start, raw: expr[start:i]})
+		case strings.ContainsRune("=!<>~", rune(c)):
+			op := expr[i : i+1]
+			if i+1 < len(expr) && (expr[i+1] == '=' || c == '!' && expr[i+1] == '~') {
+				op = expr[i : i+2]
+			}
+			if op == "!" {
+				return nil, &FilterError{Expr: expr, Pos: i, Msg: `unexpected "!", use not`}
+			}
+			toks = append(toks, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		case isWordByte(c):
+			start := i
+			for i < len(expr) && isWordByte(expr[i]) {
+				i++
+			}
+			toks = append(toks, token{kind: tokWord, text: expr[start:i], pos: start})
+		default:
+			return nil, &FilterError{Expr: expr, Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(expr)}), nil
+}
+
+type filterParser struct {
+	expr string
+	toks []token
+	i    int
+}
+
+func (p *filterParser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *filterParser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// keyword reports if the next token is the word kw and consumes it
+func (p *filterParser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokWord && t.text == kw {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) errorf(pos int, format string, args ...interface{}) error {
+	return &FilterError{Expr: p.expr, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) parseOr() (func(Slot) bool, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		lhs := l
+		l = func(s Slot) bool { return lhs(s) || r(s) }
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseAnd() (func(Slot) bool, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		lhs := l
+		l = func(s Slot) bool { return lhs(s) && r(s) }
+	}
+	return l, nil
+}
+
+func (p *filterParser) parseUnary() (func(Slot) bool, error) {
+	if p.keyword("not") {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(s Slot) bool { return !n(s) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (func(Slot) bool, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokLParen:
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if r := p.next(); r.kind != tokRParen {
+			return nil, p.errorf(r.pos, "expected \")\" to close \"(\" at column %d, found %s", t.pos+1, r)
+		}
+		return e, nil
+	case t.kind != tokWord || t.text == "and" || t.text == "or":
+		return nil, p.errorf(t.pos, "expected field, flag or \"(\", found %s", t)
+	}
+	if p.peek().kind == tokOp {
+		return p.parseComparison(t)
+	}
+	if f, ok := filterFlags[t.text]; ok {
+		return f, nil
+	}
+	if _, ok := filterFields[t.text]; ok {
+		n := p.peek()
+		return nil, p.errorf(n.pos, "expected operator after %q, found %s", t.text, n)
+	}
+	return nil, p.errorf(t.pos, "unknown field or flag %q", t.text)
+}
+
+func (p *filterParser) parseComparison(name token) (func(Slot) bool, error) {
+	field, ok := filterFields[name.text]
+	if !ok {
+		if _, flag := filterFlags[name.text]; flag {
+			return nil, p.errorf(name.pos, "%q is a flag and cannot be compared", name.text)
+		}
+		return nil, p.errorf(name.pos, "unknown field %q", name.text)
+	}
+	op := p.next()
+	if !strings.Contains(" "+field.ops+" ", " "+op.text+" ") {
+		return nil, p.errorf(op.pos, "operator %s not valid for %s, use one of %s", op.text, name.text, field.ops)
+	}
+	v := p.next()
+	if v.kind != tokWord && v.kind != tokString {
+		return nil, p.errorf(v.pos, "expected value after %s, found %s", op.text, v)
+	}
+	return field.compile(p, op.text, v)
+}
+
+// filterField is a field that can be compared in a filter
+type filterField struct {
+	// ops are the valid operators separated by spaces
+	ops     string
+	compile func(p *filterParser, op string, v token) (func(Slot) bool, error)
+}
+
+var filterFields = map[string]filterField{
+	"type":    {ops: "= !=", compile: compileType},
+	"id":      numField(func(s Slot) string { return s.ID }),
+	"home":    numField(func(s Slot) string { return volAttr(s, func(v *Volume) string { return v.Home }) }),
+	"drive":   numField(func(s Slot) string { return volAttr(s, func(v *Volume) string { return v.Drive }) }),
+	"gen":     {ops: "= != < <= > >=", compile: compileGen},
+	"barcode": {ops: "= != ~ !~", compile: compileBarcode},
+}
+
+var filterFlags = map[string]func(Slot) bool{
+	"empty": func(s Slot) bool { return s.Vol == nil },
+	"full":  func(s Slot) bool { return s.Vol != nil },
+	"cleaning": func(s Slot) bool {
+		return s.Vol != nil && clnRxp.MatchString(s.Vol.ID)
+	},
+	"labeled": func(s Slot) bool { return s.Vol != nil && s.Vol.Labeled() },
+	"loaded":  func(s Slot) bool { return s.Vol != nil && s.Vol.Drive != "" },
+}
+
+// volAttr returns an attribute of the volume in s or "" if s is empty
+func volAttr(s Slot, f func(*Volume) string) string {
+	if s.Vol == nil {
+		return ""
+	}
+	return f(s.Vol)
+}
+
+func compileType(p *filterParser, op string, v token) (func(Slot) bool, error) {
+	var t SlotType
+	switch strings.ToLower(v.text) {
+	case "drive":
+		t = DataTransferElement
+	case "storage":
+		t = StorageElement
+	case "mailbox":
+		t = ImportExport
+	default:
+		return nil, p.errorf(v.pos, "unknown element type %q, use drive, storage or mailbox", v.text)
+	}
+	if op == "!=" {
+		return func(s Slot) bool { return s.Type != t }, nil
+	}
+	return func(s Slot) bool { return s.Type == t }, nil
+}
+
+// numField is a field compared as a number, get returns "" when the
+// field does not apply to an element
+func numField(get func(Slot) string) filterField {
+	return filterField{
+		ops: "= != < <= > >=",
+		compile: func(p *filterParser, op string, v token) (func(Slot) bool, error) {
+			cmp, err := compileNum(p, op, v)
+			if err != nil {
+				return nil, err
+			}
+			return func(s Slot) bool {
+				n, err := strconv.Atoi(get(s))
+				return err == nil && cmp(n)
+			}, nil
+		},
+	}
+}
+
+func compileGen(p *filterParser, op string, v token) (func(Slot) bool, error) {
+	cmp, err := compileNum(p, op, v)
+	if err != nil {
+		return nil, err
+	}
+	return func(s Slot) bool {
+		if s.Vol == nil {
+			return false
+		}
+		g := mediaGeneration(s.Vol.ID)
+		if g == 0 {
+			g = mediaGeneration(s.Vol.AltID)
+		}
+		return g != 0 && cmp(g)
+	}, nil
+}
+
+// compileNum compiles a comparison with a number or a LOW..HIGH range
+func compileNum(p *filterParser, op string, v token) (func(int) bool, error) {
+	if lo, hi, ok := strings.Cut(v.text, ".."); ok {
+		if op != "=" && op != "!=" {
+			return nil, p.errorf(v.pos, "range %s only valid with = or !=", v.text)
+		}
+		a, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, p.errorf(v.offset(0), "expected number, found %q", lo)
+		}
+		b, err := strconv.Atoi(hi)
+		if err != nil {
+			return nil, p.errorf(v.offset(len(lo)+2), "expected number, found %q", hi)
+		}
+		if a > b {
+			return nil, p.errorf(v.pos, "empty range %s", v.text)
+		}
+		in := op == "="
+		return func(n int) bool { return (n >= a && n <= b) == in }, nil
+	}
+	x, err := strconv.Atoi(v.text)
+	if err != nil {
+		return nil, p.errorf(v.pos, "expected number, found %s", v)
+	}
+	switch op {
+	case "=":
+		return func(n int) bool { return n == x }, nil
+	case "!=":
+		return func(n int) bool { return n != x }, nil
+	case "<":
+		return func(n int) bool { return n < x }, nil
+	case "<=":
+		return func(n int) bool { return n <= x }, nil
+	case ">":
+		return func(n int) bool { return n > x }, nil
+	}
+	return func(n int) bool { return n >= x }, nil
+}
+
+func compileBarcode(p *filterParser, op string, v token) (func(Slot) bool, error) {
+	var match func(string) bool
+	switch op {
+	case "=", "!=":
+		match = func(tag string) bool { return tag == v.text }
+	default:
+		re, err := regexp.Compile(v.text)
+		if err != nil {
+			return nil, p.errorf(v.pos, "bad regexp: %v", err)
+		}
+		match = re.MatchString
+	}
+	want := op == "=" || op == "~"
+	return func(s Slot) bool {
+		return s.Vol != nil && s.Vol.Labeled() && s.Vol.matchTag(match) == want
+	}, nil
+}
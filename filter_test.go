@@ -0,0 +1,149 @@
+package mtx
+
+import (
+	"strings"
+	"testing"
+)
+
+const filterStatus = `  Storage Changer /dev/sga:2 Drives, 8 Slots ( 2 Import/Export )
+Data Transfer Element 0:Full (Storage Element 2 Loaded):VolumeTag = M00002L8
+Data Transfer Element 1:Empty
+      Storage Element 1:Full :VolumeTag=M00001L8
+      Storage Element 2:Empty
+      Storage Element 3:Full :VolumeTag=M00003L7
+      Storage Element 4:Full :VolumeTag=CLN004L1
+      Storage Element 5:Full :VolumeTag=W00005LY
+      Storage Element 6:Full
+      Storage Element 7 IMPORT/EXPORT:Full :VolumeTag=X00007M8:AlternateVolumeTag=ALT7
+      Storage Element 8 IMPORT/EXPORT:Empty
+`
+
+func elementNames(slots []Slot) string {
+	var s []string
+	for _, slot := range slots {
+		s = append(s, slot.Type.String()+" "+slot.ID)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestFilterSelect(t *testing.T) {
+	m, err := parseStatus(strings.NewReader(filterStatus))
+	if err != nil {
+		t.Fatalf("parseStatus(): %v", err)
+	}
+	for _, tc := range []struct {
+		expr string
+		want string
+	}{
+		{`type=storage and barcode~"^M0[0-9]+L8$" and not cleaning`, "storage 1"},
+		{`type=drive`, "drive 0,drive 1"},
+		{`type != storage and empty`, "drive 1,mailbox 8"},
+		{`id=2..4`, "storage 2,storage 3,storage 4"},
+		{`id!=2..7 and type=storage`, "storage 1"},
+		{`id>=7`, "mailbox 7,mailbox 8"},
+		{`id<1`, "drive 0"},
+		{`home=2`, "drive 0"},
+		{`drive=0`, "drive 0"},
+		{`loaded or cleaning`, "drive 0,storage 4"},
+		{`gen=8`, "drive 0,storage 1,storage 5,mailbox 7"},
+		{`gen>=8 and not loaded`, "storage 1,storage 5,mailbox 7"},
+		{`gen<7`, "storage 4"},
+		{`gen!=8`, "storage 3,storage 4"},
+		{`full and not labeled`, "storage 6"},
+		{`barcode=ALT7`, "mailbox 7"},
+		{`barcode!=M00001L8 and type=storage`, "storage 3,storage 4,storage 5"},
+		{`barcode!~"^M"`, "storage 4,storage 5,mailbox 7"},
+		{`not (type=drive or type=storage)`, "mailbox 7,mailbox 8"},
+		{`type=mailbox or type=drive and full`, "drive 0,mailbox 7,mailbox 8"},
+		{`(type=mailbox or type=drive) and full`, "drive 0,mailbox 7"},
+		{`not not empty and type=storage`, "storage 2"},
+		{`barcode~"L\d$" and type=storage`, "storage 1,storage 3,storage 4"},
+	} {
+		f, err := ParseFilter(tc.expr)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", tc.expr, err)
+			continue
+		}
+		if got := elementNames(m.Select(f)); got != tc.want {
+			t.Errorf("Select(%q): expected %q, got %q", tc.expr, tc.want, got)
+		}
+		if f.String() != tc.expr {
+			t.Errorf("String(): expected %q, got %q", tc.expr, f.String())
+		}
+	}
+
+	f, err := ParseFilter("gen=8")
+	if err != nil {
+		t.Fatalf("ParseFilter(): %v", err)
+	}
+	vols := m.Volumes().InElements(StorageElement).Where(f).All()
+	if barcodes(vols) != "M00001L8,W00005LY" {
+		t.Errorf("Where(gen=8): got %v", barcodes(vols))
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		pos  int
+		msg  string
+	}{
+		{``, 0, `expected field, flag or "(", found end of expression`},
+		{`type=storage and`, 16, `expected field, flag or "(", found end of expression`},
+		{`type=storage and barcod~"M"`, 17, `unknown field "barcod"`},
+		{`fulll`, 0, `unknown field or flag "fulll"`},
+		{`type~drive`, 4, `operator ~ not valid for type, use one of = !=`},
+		{`type=tape`, 5, `unknown element type "tape", use drive, storage or mailbox`},
+		{`id<abc`, 3, `expected number, found "abc"`},
+		{`id=1..x`, 6, `expected number, found "x"`},
+		{`id="1..x"`, 7, `expected number, found "x"`},
+		{`id= "1\\..x"`, 5, `expected number, found "1\\"`},
+		{`id= "1..\\x"`, 8, `expected number, found "\\x"`},
+		{`id<1..5`, 3, `range 1..5 only valid with = or !=`},
+		{`id=9..5`, 3, `empty range 9..5`},
+		{`barcode~"M0[0-9"`, 8, "bad regexp: error parsing regexp: missing closing ]: `[0-9`"},
+		{`barcode="M00001`, 8, `unterminated string`},
+		{`(full or empty`, 14, `expected ")" to close "(" at column 1, found end of expression`},
+		{`full)`, 4, `unexpected ")"`},
+		{`full empty`, 5, `unexpected "empty"`},
+		{`type`, 4, `expected operator after "type", found end of expression`},
+		{`type and full`, 5, `expected operator after "type", found "and"`},
+		{`empty=1`, 0, `"empty" is a flag and cannot be compared`},
+		{`id=`, 3, `expected value after =, found end of expression`},
+		{`!full`, 0, `unexpected "!", use not`},
+		{`full & empty`, 5, `unexpected character '&'`},
+		{`and full`, 0, `expected field, flag or "(", found "and"`},
+	} {
+		_, err := ParseFilter(tc.expr)
+		ferr, ok := err.(*FilterError)
+		if !ok {
+			t.Errorf("ParseFilter(%q): expected *FilterError, got %v", tc.expr, err)
+			continue
+		}
+		if ferr.Pos != tc.pos || ferr.Msg != tc.msg || ferr.Expr != tc.expr {
+			t.Errorf("ParseFilter(%q): expected %d %q, got %d %q", tc.expr, tc.pos, tc.msg, ferr.Pos, ferr.Msg)
+		}
+	}
+	_, err := ParseFilter(`id=x`)
+	if want := `filter: column 4: expected number, found "x"`; err == nil || err.Error() != want {
+		t.Errorf("Error(): expected %q, got %v", want, err)
+	}
+}
+
+func TestMediaGeneration(t *testing.T) {
+	for barcode, want := range map[string]int{
+		"M00001L6": 6,
+		"M00001L9": 9,
+		"W00001LT": 3,
+		"W00001LZ": 9,
+		"X00001M8": 8,
+		"CLN001L1": 1,
+		"CLN001CU": 0,
+		"ABC":      0,
+		"":         0,
+	} {
+		if got := mediaGeneration(barcode); got != want {
+			t.Errorf("mediaGeneration(%q): expected %v, got %v", barcode, want, got)
+		}
+	}
+}
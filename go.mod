@@ -0,0 +1,5 @@
+module github.com/benmcclelland/mtx
+
+go 1.23
+
+require github.com/pkg/errors v0.9.1
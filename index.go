@@ -0,0 +1,92 @@
+package mtx
+
+import "github.com/pkg/errors"
+
+// elementRef identifies an element in a MediaInfo.  The zero value,
+// with an Unknown type, marks a barcode held by more than one volume.
+type elementRef struct {
+	typ SlotType
+	id  string
+}
+
+// elements returns the element map for slot type t
+func (mi *MediaInfo) elements(t SlotType) map[string]Slot {
+	switch t {
+	case DataTransferElement:
+		return mi.Drives
+	case StorageElement:
+		return mi.Slots
+	case ImportExport:
+		return mi.Mboxes
+	}
+	return nil
+}
+
+// buildIndex indexes the primary and alternate tags of every volume
+func (mi *MediaInfo) buildIndex() {
+	mi.index = make(map[string]elementRef, len(mi.Drives)+len(mi.Slots)+len(mi.Mboxes))
+	for _, m := range []map[string]Slot{mi.Drives, mi.Slots, mi.Mboxes} {
+		for _, s := range m {
+			mi.indexSlot(s)
+		}
+	}
+}
+
+// indexSlot adds the tags of the volume in s to the index
+func (mi *MediaInfo) indexSlot(s Slot) {
+	if mi.index == nil || s.Vol == nil {
+		return
+	}
+	ref := elementRef{typ: s.Type, id: s.ID}
+	for _, tag := range [...]string{s.Vol.ID, s.Vol.AltID} {
+		if tag == "" {
+			continue
+		}
+		if old, ok := mi.index[tag]; ok && old != ref {
+			mi.index[tag] = elementRef{}
+			continue
+		}
+		mi.index[tag] = ref
+	}
+}
+
+// unindexSlot removes the tags of the volume in s from the index.
+// Tags held by more than one volume stay ambiguous until the next
+// Status.
+func (mi *MediaInfo) unindexSlot(s Slot) {
+	if mi.index == nil || s.Vol == nil {
+		return
+	}
+	ref := elementRef{typ: s.Type, id: s.ID}
+	for _, tag := range [...]string{s.Vol.ID, s.Vol.AltID} {
+		if tag != "" && mi.index[tag] == ref {
+			delete(mi.index, tag)
+		}
+	}
+}
+
+// setSlot stores s in the element map for its type, keeping the
+// barcode index current.  When moving a volume the source must be
+// emptied before the destination is set.
+func (mi *MediaInfo) setSlot(s Slot) {
+	m := mi.elements(s.Type)
+	mi.unindexSlot(m[s.ID])
+	m[s.ID] = s
+	mi.indexSlot(s)
+}
+
+// Locate returns the drive, storage or mailbox Slot holding the volume
+// with the given primary or alternate barcode.  If more than one volume
+// has the barcode, the first in the order of AllElements is returned.
+func (mi *MediaInfo) Locate(barcode string) (Slot, error) {
+	var slot Slot
+	found := false
+	mi.Volumes().WithBarcode(barcode).run(func(s Slot) bool {
+		slot, found = s, true
+		return false
+	})
+	if !found {
+		return Slot{}, errors.Errorf("volume %s not found", barcode)
+	}
+	return slot, nil
+}
@@ -0,0 +1,172 @@
+package mtx
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLocate(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"unload", "1", "0"}},
+		Step{Args: []string{"load", "3", "1"}},
+		Step{Args: nil},
+		Step{Args: []string{"load", "4", "0"}}))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	for _, tc := range []struct {
+		barcode string
+		typ     SlotType
+		id      string
+	}{
+		{"M00001L6", DataTransferElement, "0"},
+		{"M00003L6", StorageElement, "3"},
+		{"M00002L6", ImportExport, "5"},
+	} {
+		s, err := m.Locate(tc.barcode)
+		if err != nil {
+			t.Errorf("Locate(%v): %v", tc.barcode, err)
+			continue
+		}
+		if s.Type != tc.typ || s.ID != tc.id || s.Vol == nil || s.Vol.ID != tc.barcode {
+			t.Errorf("Locate(%v): expected %v %v, got %v %v %v", tc.barcode, tc.typ, tc.id, s.Type, s.ID, s.Vol)
+		}
+	}
+	if _, err := m.Locate("NOSUCH"); err == nil {
+		t.Errorf("Locate(NOSUCH): expected error")
+	}
+	if _, err := m.Locate(""); err == nil {
+		t.Errorf("Locate(\"\"): expected error")
+	}
+
+	old := m
+	cached := func() *MediaInfo {
+		t.Helper()
+		c, ok := lib.Cached()
+		if !ok {
+			t.Fatalf("Cached(): expected valid state")
+		}
+		return c
+	}
+	locate := func(barcode string, typ SlotType, id string) {
+		t.Helper()
+		s, err := cached().Locate(barcode)
+		if err != nil || s.Type != typ || s.ID != id {
+			t.Errorf("Locate(%v): expected %v %v, got %v %v %v", barcode, typ, id, s.Type, s.ID, err)
+		}
+	}
+	vol, _ := FindDriveVolume("M00001L6", m)
+	if err := lib.Unload(vol); err != nil {
+		t.Fatalf("Unload(): %v", err)
+	}
+	locate("M00001L6", StorageElement, "1")
+	if _, err := FindDriveVolume("M00001L6", cached()); err == nil {
+		t.Errorf("FindDriveVolume(M00001L6): expected error after unload")
+	}
+
+	vol, _ = FindStorageVolume("M00003L6", m)
+	if err := lib.Load(vol, m.Drives["1"]); err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+	locate("M00003L6", DataTransferElement, "1")
+	if _, err := FindStorageVolume("M00003L6", cached()); err == nil {
+		t.Errorf("FindStorageVolume(M00003L6): expected error after load")
+	}
+
+	// the volume is found in its current home even from an old snapshot
+	vol, _ = FindDriveVolume("M00001L6", m)
+	if err := lib.Transfer(vol, m.Slots["2"]); err != nil {
+		t.Fatalf("Transfer(): %v", err)
+	}
+	locate("M00001L6", StorageElement, "2")
+
+	if err := lib.LoadCln(m.Drives["0"]); err != nil {
+		t.Fatalf("LoadCln(): %v", err)
+	}
+	locate("CLN004L6", DataTransferElement, "0")
+	if v := cached().Drives["0"].Vol; v.Drive != "0" {
+		t.Errorf("LoadCln(): expected volume Drive \"0\", got %q", v.Drive)
+	}
+
+	// the first snapshot is unchanged
+	locate = func(barcode string, typ SlotType, id string) {
+		t.Helper()
+		s, err := old.Locate(barcode)
+		if err != nil || s.Type != typ || s.ID != id {
+			t.Errorf("old Locate(%v): expected %v %v, got %v %v %v", barcode, typ, id, s.Type, s.ID, err)
+		}
+	}
+	locate("M00001L6", DataTransferElement, "0")
+	locate("M00003L6", StorageElement, "3")
+	locate("CLN004L6", StorageElement, "4")
+	if v := old.Drives["0"].Vol; v.Drive != "0" || v.Home != "1" {
+		t.Errorf("old Drives[0]: expected home 1 drive 0, got %v %v", v.Home, v.Drive)
+	}
+}
+
+func TestLocateDuplicate(t *testing.T) {
+	m, err := parseStatus(bytes.NewReader([]byte(`  Storage Changer /dev/sga:1 Drives, 3 Slots ( 0 Import/Export )
+Data Transfer Element 0:Full (Storage Element 1 Loaded):VolumeTag = DUP001
+      Storage Element 1:Empty
+      Storage Element 2:Full :VolumeTag=DUP001
+      Storage Element 3:Full :VolumeTag=UNIQUE:AlternateVolumeTag=DUP001
+`)))
+	if err != nil {
+		t.Fatalf("parseStatus(): %v", err)
+	}
+	if s, err := m.Locate("DUP001"); err != nil || s.Type != DataTransferElement {
+		t.Errorf("Locate(DUP001): expected drive first, got %v %v", s, err)
+	}
+	if vols, err := FindStorageVolumes("DUP", &m); err != nil || len(vols) != 2 {
+		t.Errorf("FindStorageVolumes(DUP): expected 2 volumes, got %v %v", len(vols), err)
+	}
+	if v, err := FindStorageVolume("DUP001", &m); err != nil || v.Home == "" {
+		t.Errorf("FindStorageVolume(DUP001): %v %v", v, err)
+	}
+	if s, err := m.Locate("UNIQUE"); err != nil || s.ID != "3" {
+		t.Errorf("Locate(UNIQUE): expected slot 3, got %v %v", s, err)
+	}
+
+	// removing one copy leaves the barcode ambiguous but still found
+	m.setSlot(Slot{Type: StorageElement, ID: "2", Address: -1})
+	if v, err := FindStorageVolume("DUP001", &m); err != nil || v.Home != "3" {
+		t.Errorf("FindStorageVolume(DUP001): expected slot 3, got %v %v", v, err)
+	}
+}
+
+func TestLocateUnindexed(t *testing.T) {
+	m := MediaInfo{
+		Drives: DriveInfo{"0": {Type: DataTransferElement, ID: "0", Address: -1}},
+		Slots: SlotInfo{"1": {Type: StorageElement, ID: "1", Address: -1,
+			Vol: &Volume{ID: "ABC001", Home: "1"}}},
+		Mboxes: MboxInfo{},
+	}
+	if s, err := m.Locate("ABC001"); err != nil || s.ID != "1" {
+		t.Errorf("Locate(ABC001): expected slot 1, got %v %v", s, err)
+	}
+	if v, err := FindStorageVolume("ABC001", &m); err != nil || v.ID != "ABC001" {
+		t.Errorf("FindStorageVolume(ABC001): %v %v", v, err)
+	}
+}
+
+func BenchmarkFindStorageVolume10k(b *testing.B) {
+	m, err := parseStatus(bytes.NewReader(largeStatus(10000)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	barcodes := make([]string, 0, len(m.Slots))
+	for _, s := range m.Slots {
+		if s.Vol != nil {
+			barcodes = append(barcodes, s.Vol.ID)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := FindStorageVolume(barcodes[i%len(barcodes)], &m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
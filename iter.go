@@ -0,0 +1,95 @@
+package mtx
+
+import (
+	"cmp"
+	"iter"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// compareIDs orders element IDs numerically.  IDs that are not numbers
+// sort after those that are.
+func compareIDs(a, b string) int {
+	na, erra := strconv.Atoi(a)
+	nb, errb := strconv.Atoi(b)
+	switch {
+	case erra == nil && errb == nil:
+		if c := cmp.Compare(na, nb); c != 0 {
+			return c
+		}
+	case erra == nil:
+		return -1
+	case errb == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// sortedSlots returns the slots in m in numeric ID order
+func sortedSlots(m map[string]Slot) []Slot {
+	s := make([]Slot, 0, len(m))
+	for _, slot := range m {
+		s = append(s, slot)
+	}
+	slices.SortFunc(s, func(a, b Slot) int { return compareIDs(a.ID, b.ID) })
+	return s
+}
+
+// Sorted returns the drives in numeric ID order
+func (d DriveInfo) Sorted() []Slot {
+	return sortedSlots(d)
+}
+
+// Sorted returns the storage slots in numeric ID order
+func (s SlotInfo) Sorted() []Slot {
+	return sortedSlots(s)
+}
+
+// Sorted returns the mailbox slots in numeric ID order
+func (m MboxInfo) Sorted() []Slot {
+	return sortedSlots(m)
+}
+
+// seq iterates over the slots of each of types in numeric ID order,
+// skipping those for which keep returns false
+func (mi *MediaInfo) seq(keep func(Slot) bool, types ...SlotType) iter.Seq[Slot] {
+	return func(yield func(Slot) bool) {
+		for _, t := range types {
+			for _, s := range sortedSlots(mi.elements(t)) {
+				if keep != nil && !keep(s) {
+					continue
+				}
+				if !yield(s) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// AllElements iterates over the drives, then the storage slots, then
+// the mailbox slots, each in numeric ID order
+func (mi *MediaInfo) AllElements() iter.Seq[Slot] {
+	return mi.seq(nil, DataTransferElement, StorageElement, ImportExport)
+}
+
+// AllDrives iterates over the drives in numeric ID order
+func (mi *MediaInfo) AllDrives() iter.Seq[Slot] {
+	return mi.seq(nil, DataTransferElement)
+}
+
+// StorageSlots iterates over the storage slots in numeric ID order
+func (mi *MediaInfo) StorageSlots() iter.Seq[Slot] {
+	return mi.seq(nil, StorageElement)
+}
+
+// MailboxSlots iterates over the mailbox slots in numeric ID order
+func (mi *MediaInfo) MailboxSlots() iter.Seq[Slot] {
+	return mi.seq(nil, ImportExport)
+}
+
+// EmptySlots iterates over the empty storage slots in numeric ID order
+func (mi *MediaInfo) EmptySlots() iter.Seq[Slot] {
+	return mi.seq(func(s Slot) bool { return s.Vol == nil }, StorageElement)
+}
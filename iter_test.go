@@ -0,0 +1,120 @@
+package mtx
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const orderStatus = `  Storage Changer /dev/sga:3 Drives, 12 Slots ( 2 Import/Export )
+Data Transfer Element 0:Empty
+Data Transfer Element 1:Full (Storage Element 10 Loaded):VolumeTag = A00010
+Data Transfer Element 2:Empty
+      Storage Element 1:Full :VolumeTag=A00001
+      Storage Element 2:Empty
+      Storage Element 3:Full :VolumeTag=CLN003
+      Storage Element 4:Full :VolumeTag=A00004
+      Storage Element 5:Empty
+      Storage Element 6:Full :VolumeTag=A00006
+      Storage Element 7:Full :VolumeTag=A00007
+      Storage Element 8:Full :VolumeTag=CLN008
+      Storage Element 9:Full :VolumeTag=A00009
+      Storage Element 10:Empty
+      Storage Element 11 IMPORT/EXPORT:Full :VolumeTag=A00011
+      Storage Element 12 IMPORT/EXPORT:Empty
+`
+
+func parseOrderStatus(t *testing.T) *MediaInfo {
+	t.Helper()
+	m, err := parseStatus(bytes.NewReader([]byte(orderStatus)))
+	if err != nil {
+		t.Fatalf("parseStatus(): %v", err)
+	}
+	return &m
+}
+
+func ids(slots []Slot) string {
+	var s []string
+	for _, slot := range slots {
+		s = append(s, slot.ID)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestSorted(t *testing.T) {
+	m := parseOrderStatus(t)
+	if got := ids(m.Slots.Sorted()); got != "1,2,3,4,5,6,7,8,9,10" {
+		t.Errorf("SlotInfo.Sorted(): got %v", got)
+	}
+	if got := ids(m.Drives.Sorted()); got != "0,1,2" {
+		t.Errorf("DriveInfo.Sorted(): got %v", got)
+	}
+	if got := ids(m.Mboxes.Sorted()); got != "11,12" {
+		t.Errorf("MboxInfo.Sorted(): got %v", got)
+	}
+	if got := ids(sortedSlots(map[string]Slot{"b": {ID: "b"}, "10": {ID: "10"}, "a": {ID: "a"}, "9": {ID: "9"}})); got != "9,10,a,b" {
+		t.Errorf("sortedSlots(): got %v", got)
+	}
+}
+
+func TestIterators(t *testing.T) {
+	m := parseOrderStatus(t)
+	collect := func(seq func(func(Slot) bool)) string {
+		var slots []Slot
+		for s := range seq {
+			slots = append(slots, s)
+		}
+		return ids(slots)
+	}
+	for _, tc := range []struct {
+		name string
+		seq  func(func(Slot) bool)
+		want string
+	}{
+		{"AllElements", m.AllElements(), "0,1,2,1,2,3,4,5,6,7,8,9,10,11,12"},
+		{"AllDrives", m.AllDrives(), "0,1,2"},
+		{"StorageSlots", m.StorageSlots(), "1,2,3,4,5,6,7,8,9,10"},
+		{"MailboxSlots", m.MailboxSlots(), "11,12"},
+		{"EmptySlots", m.EmptySlots(), "2,5,10"},
+	} {
+		if got := collect(tc.seq); got != tc.want {
+			t.Errorf("%v(): expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+
+	n := 0
+	for range m.StorageSlots() {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Errorf("StorageSlots(): expected to stop after 3, got %v", n)
+	}
+}
+
+func TestQueryOrder(t *testing.T) {
+	m := parseOrderStatus(t)
+	if got := strings.Join(GetEmptyDrives(*m), ","); got != "0,2" {
+		t.Errorf("GetEmptyDrives(): expected 0,2, got %v", got)
+	}
+	var cln []string
+	for _, v := range FindCleaningMedia(*m) {
+		cln = append(cln, v.ID)
+	}
+	if got := strings.Join(cln, ","); got != "CLN003,CLN008" {
+		t.Errorf("FindCleaningMedia(): expected CLN003,CLN008, got %v", got)
+	}
+	vols, err := FindStorageVolumes("A", m)
+	if err != nil {
+		t.Fatalf("FindStorageVolumes(): %v", err)
+	}
+	var homes []string
+	for _, v := range vols {
+		homes = append(homes, v.Home)
+	}
+	if got := strings.Join(homes, ","); got != "1,4,6,7,9" {
+		t.Errorf("FindStorageVolumes(A): expected 1,4,6,7,9, got %v", got)
+	}
+}
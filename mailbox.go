@@ -0,0 +1,273 @@
+package mtx
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// MoveResult is the outcome for one volume of a bulk operation
+type MoveResult struct {
+	// Vol is the volume as it was before the move
+	Vol *Volume
+	// Src is the element the volume was in
+	Src Slot
+	// Dst is the element the volume was moved to, or would have been
+	// moved to if Err is not nil.  Dst is the zero Slot if no element
+	// was available.
+	Dst Slot
+	// Err is the reason the volume was not moved, or nil
+	Err error
+}
+
+// errNotAttempted is the MoveResult error for volumes skipped after the
+// changer state was lost
+var errNotAttempted = errors.New("not attempted, changer state unknown")
+
+// Import will attempt to move the volume in mailbox element mbox to an
+// empty storage slot and returns the slot used.  The slot is chosen as
+// for UnloadAnywhere with LowestSlot.  Import requires the cached state
+// from Status.
+func (l *Library) Import(mbox Slot) (Slot, error) {
+	return l.ImportContext(context.Background(), mbox)
+}
+
+// ImportContext is like Import but gives up if ctx is done before the
+// Library lock is acquired.  If ctx is done while the move is in
+// progress, an error with cause ErrStateUnknown is returned.
+func (l *Library) ImportContext(ctx context.Context, mbox Slot) (Slot, error) {
+	return l.ImportIf(ctx, mbox)
+}
+
+// ImportIf is like ImportContext but first checks conds against the
+// cached state.  If one does not hold, a *ConflictError is returned
+// without running mtx.
+func (l *Library) ImportIf(ctx context.Context, mbox Slot, conds ...Condition) (Slot, error) {
+	if err := l.lock(ctx); err != nil {
+		return Slot{}, errors.Wrap(err, "import")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("import", conds); err != nil {
+		return Slot{}, err
+	}
+	if !l.initialized {
+		return Slot{}, errors.New("import: no cached state, run Status first")
+	}
+	if mbox.Type != ImportExport {
+		return Slot{}, errors.Errorf("import: %v element %v is not a mailbox", mbox.Type, mbox.ID)
+	}
+	src, ok := l.mi.Mboxes[mbox.ID]
+	if !ok {
+		return Slot{}, errors.Errorf("import: no mailbox element %v", mbox.ID)
+	}
+	if src.Vol == nil {
+		return Slot{}, errors.Errorf("import: mailbox element %v is empty", mbox.ID)
+	}
+	dst, ok := l.emptySlot(src.Vol, LowestSlot)
+	if !ok {
+		return Slot{}, errors.New("import: no empty storage slot")
+	}
+	return dst, errors.Wrap(l.transfer(ctx, src, dst), "import")
+}
+
+// ImportTo will attempt to move the volume in mailbox element mbox to
+// storage slot slot
+func (l *Library) ImportTo(mbox, slot Slot) error {
+	return l.ImportToContext(context.Background(), mbox, slot)
+}
+
+// ImportToContext is like ImportTo but gives up if ctx is done before
+// the Library lock is acquired.  If ctx is done while the move is in
+// progress, an error with cause ErrStateUnknown is returned.
+func (l *Library) ImportToContext(ctx context.Context, mbox, slot Slot) error {
+	return l.ImportToIf(ctx, mbox, slot)
+}
+
+// ImportToIf is like ImportToContext but first checks conds against the
+// cached state.  If one does not hold, a *ConflictError is returned
+// without running mtx.
+func (l *Library) ImportToIf(ctx context.Context, mbox, slot Slot, conds ...Condition) error {
+	if err := l.lock(ctx); err != nil {
+		return errors.Wrap(err, "import")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("import", conds); err != nil {
+		return err
+	}
+	if mbox.Type != ImportExport {
+		return errors.Errorf("import: %v element %v is not a mailbox", mbox.Type, mbox.ID)
+	}
+	if slot.Type != StorageElement {
+		return errors.Errorf("import: %v element %v is not a storage slot", slot.Type, slot.ID)
+	}
+	return errors.Wrap(l.transfer(ctx, mbox, slot), "import")
+}
+
+// ImportAll will attempt to import the volumes in every mailbox element,
+// in mailbox order, choosing storage slots the same way as Import.  A
+// result is returned for each full mailbox element.  The error is only
+// set if the import could not be started.
+func (l *Library) ImportAll() ([]MoveResult, error) {
+	return l.ImportAllContext(context.Background())
+}
+
+// ImportAllContext is like ImportAll but gives up if ctx is done before
+// the Library lock is acquired.  Once ctx is done, no further volumes
+// are moved.
+func (l *Library) ImportAllContext(ctx context.Context) ([]MoveResult, error) {
+	return l.ImportAllIf(ctx)
+}
+
+// ImportAllIf is like ImportAllContext but first checks conds against
+// the cached state.  If one does not hold, a *ConflictError is returned
+// without moving any volumes.
+func (l *Library) ImportAllIf(ctx context.Context, conds ...Condition) ([]MoveResult, error) {
+	if err := l.lock(ctx); err != nil {
+		return nil, errors.Wrap(err, "importall")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("importall", conds); err != nil {
+		return nil, err
+	}
+	if !l.initialized {
+		return nil, errors.New("importall: no cached state, run Status first")
+	}
+	var results []MoveResult
+	for _, src := range l.mi.Mboxes.Sorted() {
+		if src.Vol == nil {
+			continue
+		}
+		v := *src.Vol
+		r := MoveResult{Vol: &v, Src: src}
+		switch {
+		case !l.initialized:
+			r.Err = errNotAttempted
+		case ctx.Err() != nil:
+			r.Err = ctx.Err()
+		default:
+			dst, ok := l.emptySlot(src.Vol, LowestSlot)
+			if !ok {
+				r.Err = errors.New("no empty storage slot")
+				break
+			}
+			r.Dst = dst
+			r.Err = l.transfer(ctx, src, dst)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// Export will attempt to move vol to the empty mailbox element mbox.
+// vol may be in a drive or a storage slot and may come from any
+// earlier snapshot, it is not modified.
+func (l *Library) Export(vol *Volume, mbox Slot) error {
+	return l.ExportContext(context.Background(), vol, mbox)
+}
+
+// ExportContext is like Export but gives up if ctx is done before the
+// Library lock is acquired.  If ctx is done while the move is in
+// progress, an error with cause ErrStateUnknown is returned.
+func (l *Library) ExportContext(ctx context.Context, vol *Volume, mbox Slot) error {
+	return l.ExportIf(ctx, vol, mbox)
+}
+
+// ExportIf is like ExportContext but first checks conds against the
+// cached state.  If one does not hold, a *ConflictError is returned
+// without running mtx.
+func (l *Library) ExportIf(ctx context.Context, vol *Volume, mbox Slot, conds ...Condition) error {
+	if err := l.lock(ctx); err != nil {
+		return errors.Wrap(err, "export")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("export", conds); err != nil {
+		return err
+	}
+	if mbox.Type != ImportExport {
+		return errors.Errorf("export: %v element %v is not a mailbox", mbox.Type, mbox.ID)
+	}
+	vol, err := l.current(vol)
+	if err != nil {
+		return errors.Wrap(err, "export")
+	}
+	src, err := l.elementOf(vol)
+	if err != nil {
+		return errors.Wrap(err, "export")
+	}
+	return errors.Wrap(l.transfer(ctx, src, mbox), "export")
+}
+
+// ExportMany will attempt to move vols to the empty mailbox elements in
+// mailbox order, with one move for each volume.  Volumes already in a
+// mailbox are not moved.  A result is returned for each volume, in the
+// order of vols; volumes that do not fit in the mailbox have an error
+// and a zero Dst, as do nil entries.  The error is only set if the
+// export could not be started.
+func (l *Library) ExportMany(vols []*Volume) ([]MoveResult, error) {
+	return l.ExportManyContext(context.Background(), vols)
+}
+
+// ExportManyContext is like ExportMany but gives up if ctx is done
+// before the Library lock is acquired.  Once ctx is done, no further
+// volumes are moved.
+func (l *Library) ExportManyContext(ctx context.Context, vols []*Volume) ([]MoveResult, error) {
+	return l.ExportManyIf(ctx, vols)
+}
+
+// ExportManyIf is like ExportManyContext but first checks conds against
+// the cached state.  If one does not hold, a *ConflictError is returned
+// without moving any volumes.
+func (l *Library) ExportManyIf(ctx context.Context, vols []*Volume, conds ...Condition) ([]MoveResult, error) {
+	if err := l.lock(ctx); err != nil {
+		return nil, errors.Wrap(err, "exportmany")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("exportmany", conds); err != nil {
+		return nil, err
+	}
+	if !l.initialized {
+		return nil, errors.New("exportmany: no cached state, run Status first")
+	}
+	var free []Slot
+	for _, s := range l.mi.Mboxes.Sorted() {
+		if s.Vol == nil {
+			free = append(free, s)
+		}
+	}
+	results := make([]MoveResult, 0, len(vols))
+	for _, vol := range vols {
+		r := MoveResult{Vol: vol}
+		var src Slot
+		cur, err := l.current(vol)
+		if err == nil {
+			v := *cur
+			r.Vol = &v
+			src, err = l.elementOf(cur)
+			r.Src = src
+		}
+		switch {
+		case !l.initialized:
+			r.Err = errNotAttempted
+		case ctx.Err() != nil:
+			r.Err = ctx.Err()
+		case err != nil:
+			r.Err = err
+		case src.Type == ImportExport:
+			r.Dst = src
+		case len(free) == 0:
+			r.Err = errors.New("no empty mailbox element")
+		default:
+			r.Dst = free[0]
+			if r.Err = l.transfer(ctx, src, free[0]); r.Err == nil {
+				free = free[1:]
+			}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
@@ -0,0 +1,177 @@
+package mtx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestImportExport(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"transfer", "5", "2"}},
+		Step{Args: []string{"transfer", "3", "5"}},
+		Step{Args: []string{"unload", "6", "0"}},
+		Step{Args: []string{"transfer", "5", "3"}},
+		Step{Args: []string{"transfer", "6", "1"}},
+	))
+	if _, err := lib.Import(Slot{Type: ImportExport, ID: "5"}); err == nil {
+		t.Errorf("Import(): expected error without status")
+	}
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+
+	// slot 1 is the home of the volume in drive 0, so slot 2 is used
+	results, err := lib.ImportAll()
+	if err != nil {
+		t.Fatalf("ImportAll(): %v", err)
+	}
+	if len(results) != 1 || results[0].Err != nil || results[0].Vol.ID != "M00002L6" ||
+		results[0].Src.ID != "5" || results[0].Dst.ID != "2" {
+		t.Errorf("ImportAll(): expected M00002L6 from 5 to 2, got %+v", results)
+	}
+
+	results, err = lib.ExportMany([]*Volume{m.Slots["3"].Vol, m.Drives["0"].Vol, m.Mboxes["5"].Vol})
+	if err != nil {
+		t.Fatalf("ExportMany(): %v", err)
+	}
+	for i, want := range []struct {
+		barcode, src, dst string
+		err               bool
+	}{
+		{"M00003L6", "3", "5", false},
+		{"M00001L6", "0", "6", false},
+		{"M00002L6", "2", "", true},
+	} {
+		r := results[i]
+		if r.Vol.ID != want.barcode || r.Src.ID != want.src || r.Dst.ID != want.dst || (r.Err != nil) != want.err {
+			t.Errorf("ExportMany()[%v]: expected %v from %v to %q, got %v from %v to %q %v",
+				i, want.barcode, want.src, want.dst, r.Vol.ID, r.Src.ID, r.Dst.ID, r.Err)
+		}
+	}
+
+	// volumes go back to their home slots
+	for _, want := range []struct{ mbox, slot string }{{"5", "3"}, {"6", "1"}} {
+		dst, err := lib.Import(Slot{Type: ImportExport, ID: want.mbox})
+		if err != nil || dst.ID != want.slot {
+			t.Errorf("Import(%v): expected slot %v, got %v %v", want.mbox, want.slot, dst.ID, err)
+		}
+	}
+	c, _ := lib.Cached()
+	if v := c.Slots["2"].Vol; v == nil || v.Home != "2" {
+		t.Errorf("ImportAll(): expected imported volume home 2, got %+v", v)
+	}
+	if v := c.Slots["1"].Vol; v == nil || v.ID != "M00001L6" || v.Location != "1" {
+		t.Errorf("Import(): expected M00001L6 in slot 1, got %+v", v)
+	}
+	if c.Mboxes["5"].Vol != nil || c.Mboxes["6"].Vol != nil {
+		t.Errorf("Import(): expected mailbox empty")
+	}
+
+	if err := lib.Export(m.Slots["3"].Vol, m.Slots["2"]); err == nil {
+		t.Errorf("Export(): expected error for storage destination")
+	}
+	if _, err := lib.Import(m.Mboxes["5"]); err == nil {
+		t.Errorf("Import(): expected error for empty mailbox")
+	}
+	if err := lib.ImportTo(m.Mboxes["5"], m.Drives["1"]); err == nil {
+		t.Errorf("ImportTo(): expected error for drive destination")
+	}
+}
+
+func TestLoadFromMailbox(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"load", "5", "1"}},
+		Step{Args: []string{"unload", "5", "1"}},
+	))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	vol, err := FindMboxVolume("M00002L6", m)
+	if err != nil {
+		t.Fatalf("FindMboxVolume(): %v", err)
+	}
+	if err := lib.Load(vol, m.Drives["1"]); err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+	c, _ := lib.Cached()
+	if c.Mboxes["5"].Vol != nil {
+		t.Errorf("Load(): expected mailbox 5 empty, got %+v", c.Mboxes["5"].Vol)
+	}
+	if v := c.Drives["1"].Vol; v == nil || v.ID != "M00002L6" || v.Drive != "1" || v.Home != "5" || v.Location != "" {
+		t.Errorf("Load(): expected M00002L6 in drive 1 from mailbox 5, got %+v", v)
+	}
+	if s, err := c.Locate("M00002L6"); err != nil || s.Type != DataTransferElement {
+		t.Errorf("Locate(): expected drive, got %v %v", s.Type, err)
+	}
+	if _, err := FindMboxVolume("M00002L6", c); err == nil {
+		t.Errorf("FindMboxVolume(): expected error after load")
+	}
+	if vol.Location != "5" || vol.Drive != "" {
+		t.Errorf("Load(): modified the snapshot volume %+v", vol)
+	}
+
+	// the volume goes back to the mailbox it came from
+	if err := lib.Unload(vol); err != nil {
+		t.Fatalf("Unload(): %v", err)
+	}
+	c, _ = lib.Cached()
+	if v := c.Mboxes["5"].Vol; v == nil || v.ID != "M00002L6" || v.Location != "5" {
+		t.Errorf("Unload(): expected M00002L6 in mailbox 5, got %+v", v)
+	}
+
+	// a volume in another drive is left to Move
+	if err := lib.Load(m.Drives["0"].Vol, m.Drives["1"]); err == nil {
+		t.Errorf("Load(): expected error for volume in another drive")
+	}
+	c, _ = lib.Cached()
+	if v := c.Drives["0"].Vol; v == nil || v.ID != "M00001L6" || c.Drives["1"].Vol != nil {
+		t.Errorf("Load(): expected M00001L6 left in drive 0, got %+v", c.Drives["1"].Vol)
+	}
+}
+
+func TestImportExportIf(t *testing.T) {
+	rec := &RecordingExecutor{Exec: NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"transfer", "3", "6"}},
+	)}
+	lib := NewLibraryExecutor("/dev/sga", rec)
+	ctx := context.Background()
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	stale := AtGeneration(m.Generation + 1)
+	check := func(name string, err error) {
+		t.Helper()
+		if _, ok := err.(*ConflictError); !ok {
+			t.Errorf("%v(): expected *ConflictError, got %v", name, err)
+		}
+	}
+	_, err = lib.ImportIf(ctx, m.Mboxes["5"], stale)
+	check("ImportIf", err)
+	check("ImportToIf", lib.ImportToIf(ctx, m.Mboxes["5"], m.Slots["2"], stale))
+	_, err = lib.ImportAllIf(ctx, stale)
+	check("ImportAllIf", err)
+	check("ExportIf", lib.ExportIf(ctx, m.Slots["3"].Vol, m.Mboxes["6"], Holding(ImportExport, "6", "M00002L6")))
+	_, err = lib.ExportManyIf(ctx, []*Volume{m.Slots["3"].Vol}, stale)
+	check("ExportManyIf", err)
+	if n := len(rec.Calls()); n != 1 {
+		t.Errorf("expected conflicts not to run mtx, got %v calls", n)
+	}
+
+	// a nil volume gets an error result without stopping the others
+	results, err := lib.ExportManyIf(ctx, []*Volume{nil, m.Slots["3"].Vol}, AtGeneration(m.Generation))
+	if err != nil {
+		t.Fatalf("ExportManyIf(): %v", err)
+	}
+	if len(results) != 2 || results[0].Err == nil || results[0].Vol != nil {
+		t.Errorf("ExportManyIf(): expected error for nil volume, got %+v", results)
+	}
+	if len(results) == 2 && (results[1].Err != nil || results[1].Dst.ID != "6") {
+		t.Errorf("ExportManyIf(): expected M00003L6 exported to 6, got %+v", results[1])
+	}
+}
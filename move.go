@@ -0,0 +1,193 @@
+package mtx
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Move will attempt to move the volume in src to the empty element dst.
+// src and dst may be any combination of drive, storage and mailbox
+// elements, usually from a snapshot.  A move between two drives goes
+// through the home slot of the volume, which must be empty.  The home
+// slot of the volume is not changed, see Volume.
+func (l *Library) Move(src, dst Slot) error {
+	return l.MoveContext(context.Background(), src, dst)
+}
+
+// MoveContext is like Move but gives up if ctx is done before the
+// Library lock is acquired.  If ctx is done while the move is in
+// progress, an error with cause ErrStateUnknown is returned.
+func (l *Library) MoveContext(ctx context.Context, src, dst Slot) error {
+	return l.MoveIf(ctx, src, dst)
+}
+
+// MoveIf is like MoveContext but first checks conds against the cached
+// state.  If one does not hold, a *ConflictError is returned without
+// running mtx.
+func (l *Library) MoveIf(ctx context.Context, src, dst Slot, conds ...Condition) error {
+	if err := l.lock(ctx); err != nil {
+		return errors.Wrap(err, "move")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("move", conds); err != nil {
+		return err
+	}
+	return errors.Wrap(l.transfer(ctx, src, dst), "move")
+}
+
+// transfer moves the volume in src to dst, checking both against the
+// cached state if there is one, and updates the cache.  If src.Vol is
+// set, the cached element must still hold that volume.  The Library
+// lock must be held.
+func (l *Library) transfer(ctx context.Context, src, dst Slot) error {
+	return l.transferHome(ctx, src, dst, false)
+}
+
+// transferHome is like transfer, and if rehome is set makes a storage
+// slot dst the home slot of the volume
+func (l *Library) transferHome(ctx context.Context, src, dst Slot, rehome bool) error {
+	want := src.Vol
+	for _, s := range []*Slot{&src, &dst} {
+		if s.Type != DataTransferElement && s.Type != StorageElement && s.Type != ImportExport {
+			return errors.Errorf("invalid element type %v for element %v", s.Type, s.ID)
+		}
+		if !l.initialized {
+			continue
+		}
+		cached, ok := l.mi.elements(s.Type)[s.ID]
+		if !ok {
+			return errors.Errorf("no %v element %v", s.Type, s.ID)
+		}
+		*s = cached
+	}
+	switch {
+	case src.Type == dst.Type && src.ID == dst.ID:
+		return errors.Errorf("source and destination are the same %v element %v", src.Type, src.ID)
+	case src.Vol == nil:
+		return errors.Errorf("%v element %v is empty", src.Type, src.ID)
+	case dst.Vol != nil:
+		return errors.Errorf("%v element %v is not empty, holding %v", dst.Type, dst.ID, dst.Vol.name())
+	case want != nil && (want.ID != src.Vol.ID || want.AltID != src.Vol.AltID):
+		return errors.Errorf("%v element %v holds %v, not %v, run Status", src.Type, src.ID, src.Vol.name(), want.name())
+	}
+
+	var err error
+	switch {
+	case src.Type == DataTransferElement && dst.Type == DataTransferElement:
+		if src.Vol.Home == "" {
+			return errors.Errorf("cannot move %v from drive %v to drive %v, no home slot", src.Vol.name(), src.ID, dst.ID)
+		}
+		home := l.storageSlot(src.Vol.Home)
+		if err := l.transfer(ctx, src, home); err != nil {
+			return err
+		}
+		home.Vol = src.Vol
+		return l.transfer(ctx, home, dst)
+	case src.Type == DataTransferElement:
+		err = l.move(ctx, "unload", dst.ID, src.ID)
+	case dst.Type == DataTransferElement:
+		err = l.move(ctx, "load", src.ID, dst.ID)
+	default:
+		err = l.move(ctx, "transfer", src.ID, dst.ID)
+	}
+	if err == nil && l.initialized {
+		l.relocate(src, dst, rehome)
+	}
+	return err
+}
+
+// relocate updates the cache after the volume in src was moved to dst,
+// making a storage slot dst the home slot if rehome is set
+func (l *Library) relocate(src, dst Slot, rehome bool) {
+	l.update(func() {
+		v := l.placed(*src.Vol, src, dst)
+		if rehome && dst.Type == StorageElement {
+			v.Home = dst.ID
+		}
+		l.mi.setSlot(Slot{Type: src.Type, ID: src.ID, Address: src.Address})
+		l.mi.setSlot(Slot{Type: dst.Type, ID: dst.ID, Address: dst.Address, Vol: &v})
+	})
+}
+
+// placed returns v as it is after a move from src to dst.  A volume
+// without a home slot is given the first storage or mailbox element it
+// is in, and a volume with a mailbox home, such as one that was
+// imported, the first storage slot it is moved to.
+func (l *Library) placed(v Volume, src, dst Slot) Volume {
+	if src.Type != DataTransferElement && v.Home == "" {
+		v.Home = src.ID
+	}
+	if dst.Type == DataTransferElement {
+		v.Drive, v.Location = dst.ID, ""
+		return v
+	}
+	v.Drive, v.Location = "", dst.ID
+	if _, ok := l.mi.Slots[v.Home]; v.Home == "" || !ok && dst.Type == StorageElement {
+		v.Home = dst.ID
+	}
+	return v
+}
+
+// elementOf returns the cached element holding vol, which must be the
+// result of current
+func (l *Library) elementOf(vol *Volume) (Slot, error) {
+	if vol.Drive != "" {
+		if l.initialized {
+			if d, ok := l.mi.Drives[vol.Drive]; ok {
+				return d, nil
+			}
+		}
+		return Slot{Type: DataTransferElement, ID: vol.Drive, Address: -1, Vol: vol}, nil
+	}
+	if vol.Location == "" {
+		return Slot{}, errors.Errorf("unknown location for volume %v", vol.name())
+	}
+	s := l.storageSlot(vol.Location)
+	if !l.initialized {
+		s.Vol = vol
+	}
+	return s, nil
+}
+
+// storageSlot returns the storage or mailbox element with the given
+// ID.  Without a cached state, a storage element is assumed since mtx
+// numbers both kinds the same way.
+func (l *Library) storageSlot(id string) Slot {
+	if l.initialized {
+		if s, ok := l.mi.storageSlot(id); ok {
+			return s
+		}
+	}
+	return Slot{Type: StorageElement, ID: id, Address: -1}
+}
+
+// storageSlot returns the storage or mailbox element with the given ID
+func (mi *MediaInfo) storageSlot(id string) (Slot, bool) {
+	if s, ok := mi.Slots[id]; ok {
+		return s, true
+	}
+	s, ok := mi.Mboxes[id]
+	return s, ok
+}
+
+// carryHomes copies the home slots of volumes that are in the same
+// element in old and mi, since mtx only reports the source of loaded
+// drives.  Volumes that were moved keep the home reported by mtx.
+func (mi *MediaInfo) carryHomes(old *MediaInfo) {
+	for _, t := range allTypes {
+		cur, prev := mi.elements(t), old.elements(t)
+		for id, s := range cur {
+			p, ok := prev[id]
+			if !ok || s.Vol == nil || p.Vol == nil || p.Vol.Home == "" ||
+				s.Vol.ID != p.Vol.ID || s.Vol.AltID != p.Vol.AltID || s.Vol.Home == p.Vol.Home {
+				continue
+			}
+			v := *s.Vol
+			v.Home = p.Vol.Home
+			s.Vol = &v
+			cur[id] = s
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package mtx
+
+import (
+	"testing"
+)
+
+func TestMove(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"transfer", "3", "6"}},
+		Step{Args: []string{"load", "6", "1"}},
+		Step{Args: []string{"unload", "2", "1"}},
+		Step{Args: []string{"unload", "1", "0"}},
+		Step{Args: []string{"load", "1", "1"}},
+		Step{Args: []string{"transfer", "5", "3"}},
+	))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	c := func() *MediaInfo {
+		t.Helper()
+		c, ok := lib.Cached()
+		if !ok {
+			t.Fatalf("Cached(): expected valid state")
+		}
+		return c
+	}
+	check := func(s Slot, barcode, home, location, drive string) {
+		t.Helper()
+		v := s.Vol
+		if v == nil || v.ID != barcode || v.Home != home || v.Location != location || v.Drive != drive {
+			t.Errorf("%v %v: expected %v home %q location %q drive %q, got %+v", s.Type, s.ID, barcode, home, location, drive, v)
+		}
+	}
+
+	// storage to mailbox, then mailbox to drive
+	if err := lib.Move(m.Slots["3"], m.Mboxes["6"]); err != nil {
+		t.Fatalf("Move(storage, mailbox): %v", err)
+	}
+	check(c().Mboxes["6"], "M00003L6", "3", "6", "")
+	if c().Slots["3"].Vol != nil {
+		t.Errorf("Move(storage, mailbox): expected storage 3 empty")
+	}
+	if err := lib.Move(c().Mboxes["6"], m.Drives["1"]); err != nil {
+		t.Fatalf("Move(mailbox, drive): %v", err)
+	}
+	check(c().Drives["1"], "M00003L6", "3", "", "1")
+
+	// drive to a slot other than home
+	if err := lib.Move(m.Drives["1"], m.Slots["2"]); err != nil {
+		t.Fatalf("Move(drive, storage): %v", err)
+	}
+	check(c().Slots["2"], "M00003L6", "3", "2", "")
+
+	// drive to drive goes through the home slot
+	if err := lib.Move(m.Drives["0"], m.Drives["1"]); err != nil {
+		t.Fatalf("Move(drive, drive): %v", err)
+	}
+	check(c().Drives["1"], "M00001L6", "1", "", "1")
+	if c().Drives["0"].Vol != nil || c().Slots["1"].Vol != nil {
+		t.Errorf("Move(drive, drive): expected drive 0 and storage 1 empty")
+	}
+
+	// Transfer uses element IDs rather than the barcode, a volume from
+	// a mailbox gets a storage home
+	if err := lib.Transfer(m.Mboxes["5"].Vol, m.Slots["3"]); err != nil {
+		t.Fatalf("Transfer(): %v", err)
+	}
+	check(c().Slots["3"], "M00002L6", "3", "3", "")
+
+	for _, tc := range []struct {
+		src, dst Slot
+		want     string
+	}{
+		{m.Slots["1"], m.Slots["2"], "move: storage element 1 is empty"},
+		{m.Slots["2"], m.Slots["3"], "move: storage element 3 is not empty, holding M00002L6"},
+		{m.Slots["4"], m.Slots["4"], "move: source and destination are the same storage element 4"},
+		{m.Slots["4"], Slot{Type: StorageElement, ID: "9"}, "move: no storage element 9"},
+		{m.Slots["4"], Slot{ID: "1"}, "move: invalid element type unknown for element 1"},
+	} {
+		if err := lib.Move(tc.src, tc.dst); err == nil || err.Error() != tc.want {
+			t.Errorf("Move(%v %v, %v %v): expected %q, got %v", tc.src.Type, tc.src.ID, tc.dst.Type, tc.dst.ID, tc.want, err)
+		}
+	}
+}
+
+func TestMoveDriveNoHome(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: `  Storage Changer /dev/sga:2 Drives, 1 Slots ( 0 Import/Export )
+Data Transfer Element 0:Full (Unknown Storage Element Loaded):VolumeTag = M00001L6
+Data Transfer Element 1:Empty
+      Storage Element 1:Empty
+`},
+		Step{Args: []string{"unload", "1", "0"}},
+	))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Move(m.Drives["0"], m.Drives["1"]); err == nil {
+		t.Errorf("Move(drive, drive): expected error without home slot")
+	}
+	if err := lib.Move(m.Drives["0"], m.Slots["1"]); err != nil {
+		t.Fatalf("Move(drive, storage): %v", err)
+	}
+	c, _ := lib.Cached()
+	if v := c.Slots["1"].Vol; v == nil || v.Home != "1" || v.Location != "1" {
+		t.Errorf("Move(drive, storage): expected home 1, got %+v", v)
+	}
+}
+
+func TestStatusKeepsHome(t *testing.T) {
+	moved := `  Storage Changer /dev/sga:2 Drives, 6 Slots ( 2 Import/Export )
+Data Transfer Element 0:Full (Storage Element 1 Loaded):VolumeTag = M00001L6
+Data Transfer Element 1:Empty
+      Storage Element 1:Empty
+      Storage Element 2:Full :VolumeTag=M00003L6
+      Storage Element 3:Empty
+      Storage Element 4:Full :VolumeTag=CLN004L6
+      Storage Element 5 IMPORT/EXPORT:Full :VolumeTag=M00002L6
+      Storage Element 6 IMPORT/EXPORT:Empty
+`
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"transfer", "3", "2"}},
+		Step{Args: []string{"status"}, Stdout: moved},
+	))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Move(m.Slots["3"], m.Slots["2"]); err != nil {
+		t.Fatalf("Move(): %v", err)
+	}
+	m, err = lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if v := m.Slots["2"].Vol; v == nil || v.Home != "3" || v.Location != "2" {
+		t.Errorf("Status(): expected home 3 kept after move, got %+v", v)
+	}
+	if v := m.Slots["4"].Vol; v == nil || v.Home != "4" {
+		t.Errorf("Status(): expected home 4, got %+v", v)
+	}
+}
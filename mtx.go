@@ -6,17 +6,14 @@ is readily available in most distros.
 package mtx
 
 import (
-	"bufio"
 	"bytes"
-	"io"
-	"io/ioutil"
+	"context"
 	"math/rand"
-	"os/exec"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/benmcclelland/mtx/scsi/smc"
 	"github.com/pkg/errors"
 )
 
@@ -34,34 +31,115 @@ const (
 	ImportExport
 )
 
-var (
-	summaryRxp  = regexp.MustCompile(`\s*Storage Changer .*:(\d*) Drives, (\d*) Slots \( (\d*) Import/Export \)`)
-	dteEmptyRxp = regexp.MustCompile(`Data Transfer Element (\d*):Empty`)
-	dteFullRxp  = regexp.MustCompile(`Data Transfer Element (\d*):Full \(Storage Element (\d*) Loaded\):VolumeTag = (\S*)`)
-	seEmptyRxp  = regexp.MustCompile(`\s*Storage Element (\d*):Empty`)
-	seFullRxp   = regexp.MustCompile(`\s*Storage Element (\d*):Full :VolumeTag=(\S*)`)
-	ieEmptyRxp  = regexp.MustCompile(`\s*Storage Element (\d*) IMPORT/EXPORT:Empty`)
-	ieFullRxp   = regexp.MustCompile(`\s*Storage Element (\d*) IMPORT/EXPORT:Full :VolumeTag=(\S*)`)
-	clnRxp      = regexp.MustCompile(`(CLN.*)`)
-)
+func (t SlotType) String() string {
+	switch t {
+	case DataTransferElement:
+		return "drive"
+	case StorageElement:
+		return "storage"
+	case ImportExport:
+		return "mailbox"
+	}
+	return "unknown"
+}
+
+var clnRxp = regexp.MustCompile(`(CLN.*)`)
+
+// ErrStateUnknown is the cause of a *StateUnknownError, returned when a
+// media movement failed or was interrupted by context cancellation
+// after the mtx command was started.  The robot may or may not have
+// completed the move, so the cached MediaInfo is invalidated and Status
+// should be called again.  A move that was never started, or that the
+// changer rejected as an Illegal Request, returns the Executor error
+// instead.
+var ErrStateUnknown = errors.New("move did not complete, changer state unknown")
+
+// StateUnknownError is returned when a move failed or was interrupted
+// after it was started.  Cause returns ErrStateUnknown and Unwrap the
+// error from the Executor, or the ctx error, so both errors.Cause and
+// errors.As, for example to an *exec.ExitError, work.
+type StateUnknownError struct {
+	Err error
+}
+
+func (e *StateUnknownError) Error() string {
+	return e.Err.Error() + ": " + ErrStateUnknown.Error()
+}
+
+// Cause returns ErrStateUnknown
+func (e *StateUnknownError) Cause() error {
+	return ErrStateUnknown
+}
+
+// Unwrap returns the error the move failed with
+func (e *StateUnknownError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrStateUnknown
+func (e *StateUnknownError) Is(target error) bool {
+	return target == ErrStateUnknown
+}
+
+// illegalRequestRxp matches the sense key of a command the changer
+// rejected without moving any media
+var illegalRequestRxp = regexp.MustCompile(`Illegal Request`)
 
 // Volume is a representation for the media in the Library
 type Volume struct {
-	// ID is the serial number for the media volume
+	// ID is the serial number for the media volume, or "" for
+	// unlabeled media and unreadable barcodes
 	ID string
-	// Home is the home storage slot for the media volume
+	// AltID is the alternate volume tag, or "" if not reported
+	AltID string
+	// Home is the storage or mailbox slot the volume returns to when
+	// unloaded.  It is the slot Status found the volume in, or the
+	// source mtx reports for a loaded drive, and is not changed by
+	// Move, except that a volume with a mailbox home is given the
+	// first storage slot it is moved to.  It is "" if mtx reports the
+	// drive was loaded from an unknown element.
 	Home string
+	// Location is the string ID of the storage or mailbox slot the
+	// volume is currently in, or "" if in a drive
+	Location string
 	// Drive is the string ID of the drive media is currently in
 	// or "" if not in a drive
 	Drive string
 }
 
+// Labeled reports if the volume has a readable primary or alternate
+// barcode.  Unlabeled volumes are never matched by the Find*Volume
+// functions, see FindUnlabeled.
+func (v *Volume) Labeled() bool {
+	return v.ID != "" || v.AltID != ""
+}
+
+// matchTag reports if f is true for the primary or alternate tag
+func (v *Volume) matchTag(f func(tag string) bool) bool {
+	return v.ID != "" && f(v.ID) || v.AltID != "" && f(v.AltID)
+}
+
+// name identifies the volume in error messages
+func (v *Volume) name() string {
+	switch {
+	case v.ID != "":
+		return v.ID
+	case v.AltID != "":
+		return v.AltID
+	}
+	return "(unlabeled)"
+}
+
 // Slot is a representation of each physical slot in the the Library
 type Slot struct {
 	// Type is the type of slot
 	Type SlotType
 	// ID is the slot identifier
 	ID string
+	// Address is the SCSI element address of the slot, or -1 if
+	// not known, see Library.ElementMap.  0 is a valid address, so
+	// a Slot built by hand must set -1 when the address is unknown.
+	Address int
 	// Volume is a pointer to the Volume currently in the slot
 	// or nil if empty
 	Vol *Volume
@@ -90,6 +168,16 @@ type MediaInfo struct {
 	Slots SlotInfo
 	// Mboxes is the Mbox representation
 	Mboxes MboxInfo
+	// Warnings lists unrecognized status output and element count
+	// mismatches when the Library is Lenient, otherwise nil
+	Warnings *ParseError
+	// Generation identifies the Library state the MediaInfo was
+	// copied from.  It increases every time the state changes.
+	Generation uint64
+
+	// index maps primary and alternate barcodes to the element
+	// holding the volume, nil if the MediaInfo was not parsed
+	index map[string]elementRef
 }
 
 // Library represents a single SCSI based media changer
@@ -98,10 +186,31 @@ type Library struct {
 	Device string
 	// Command is the mtx command used for the Library
 	Command string
-	// Protects MediaInfo and command exec
-	mu          sync.Mutex
+	// Executor runs mtx commands for the Library, if nil the
+	// Command executable is run on the local host
+	Executor Executor
+	// AddressExecutor, if set, reads the element addresses for
+	// ElementMap when Executor cannot, as with stock mtx.  An
+	// sg.Executor for the same device reads the address of each
+	// element with READ ELEMENT STATUS; other Executors run
+	// "loaderinfo".
+	AddressExecutor Executor
+	// Lenient accepts status output with unrecognized lines or
+	// element counts that disagree with the summary line, reporting
+	// them in MediaInfo.Warnings instead of failing with a ParseError
+	Lenient bool
+	// Protects MediaInfo and command exec, see lock()
+	once sync.Once
+	sem  chan struct{}
+	// mu protects the cached state below from Cached and View.  The
+	// state is only changed with both locks held.
+	mu          sync.RWMutex
 	mi          MediaInfo
 	initialized bool
+	gen         uint64
+	em          *ElementMap
+	// caps are the changer capabilities, read for the first exchange
+	caps *smc.Capabilities
 }
 
 // NewLibrary returns a Library for a given SCSI device path
@@ -114,196 +223,194 @@ func NewLibraryCmd(device, cmd string) *Library {
 	return &Library{Device: device, Command: cmd}
 }
 
-// Status returns a structured representation of the drives, slots,
-// import/export, and media locations
-func (l *Library) Status() (*MediaInfo, error) {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	result, err := mtxCmd(l.Command, l.Device, "status")
-	if err != nil {
-		return nil, errors.Wrap(err, "status")
+// NewLibraryExecutor returns a Library for a given SCSI device path
+// that runs mtx commands with the given Executor
+func NewLibraryExecutor(device string, e Executor) *Library {
+	return &Library{Device: device, Command: "mtx", Executor: e}
+}
+
+func (l *Library) executor() Executor {
+	if l.Executor != nil {
+		return l.Executor
 	}
+	return &CmdExecutor{Command: l.Command}
+}
 
-	l.mi, err = parseStatus(bytes.NewReader(result))
-	if err == nil {
-		l.initialized = true
+// lock acquires the Library lock, giving up with the context error
+// if ctx is done before the lock is available
+func (l *Library) lock(ctx context.Context) error {
+	l.once.Do(func() { l.sem = make(chan struct{}, 1) })
+	// Check first so that an already cancelled context never wins
+	// the random select choice against a free lock
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return &l.mi, errors.Wrap(err, "status")
 }
 
-func parseStatus(r io.Reader) (MediaInfo, error) {
-	lscanner := bufio.NewScanner(r)
-	var driveCnt, slotCnt, mboxCnt int
-	if lscanner.Scan() {
-		var err error
-		line := lscanner.Text()
-		match := summaryRxp.FindStringSubmatch(line)
-		if len(match) != 4 {
-			return MediaInfo{}, errors.Errorf("no summary output found")
-		}
-		driveCnt, err = strconv.Atoi(match[1])
-		if err != nil {
-			return MediaInfo{}, err
-		}
-		totslotCnt, err := strconv.Atoi(match[2])
-		if err != nil {
-			return MediaInfo{}, err
-		}
-		mboxCnt, err = strconv.Atoi(match[3])
-		if err != nil {
-			return MediaInfo{}, err
-		}
-		slotCnt = totslotCnt - mboxCnt
-
-	}
-	if err := lscanner.Err(); err != nil {
-		return MediaInfo{}, err
-	}
-
-	dmap := make(map[string]Slot)
-	smap := make(map[string]Slot)
-	mmap := make(map[string]Slot)
-	for lscanner.Scan() {
-		line := lscanner.Text()
-		// We are going to test these in the order of what we are
-		// more likely to hit a match on to try to minimize the
-		// false matches in a large library
-		// Full storage slot
-		match := seFullRxp.FindStringSubmatch(line)
-		if match != nil {
-			newVol := Volume{
-				ID:    match[2],
-				Home:  match[1],
-				Drive: "",
-			}
-			smap[match[1]] = Slot{
-				Type: StorageElement,
-				ID:   match[1],
-				Vol:  &newVol,
-			}
-			continue
-		}
-		// Empty storage slot
-		match = seEmptyRxp.FindStringSubmatch(line)
-		if match != nil {
-			smap[match[1]] = Slot{
-				Type: StorageElement,
-				ID:   match[1],
-				Vol:  nil,
-			}
-			continue
-		}
-		// Full drive slot
-		match = dteFullRxp.FindStringSubmatch(line)
-		if match != nil {
-			newVol := Volume{
-				ID:    match[3],
-				Home:  match[2],
-				Drive: match[1],
-			}
-			dmap[match[1]] = Slot{
-				Type: DataTransferElement,
-				ID:   match[1],
-				Vol:  &newVol,
-			}
-			continue
-		}
-		// Empty drive slot
-		match = dteEmptyRxp.FindStringSubmatch(line)
-		if match != nil {
-			dmap[match[1]] = Slot{
-				Type: DataTransferElement,
-				ID:   match[1],
-				Vol:  nil,
-			}
-			continue
-		}
-		// Empty mailbox slot
-		match = ieEmptyRxp.FindStringSubmatch(line)
-		if match != nil {
-			mmap[match[1]] = Slot{
-				Type: ImportExport,
-				ID:   match[1],
-				Vol:  nil,
-			}
-			continue
-		}
-		// Full mailbox slot
-		match = ieFullRxp.FindStringSubmatch(line)
-		if match != nil {
-			newVol := Volume{
-				ID:    match[2],
-				Home:  match[1],
-				Drive: "",
-			}
-			mmap[match[1]] = Slot{
-				Type: ImportExport,
-				ID:   match[1],
-				Vol:  &newVol,
-			}
-			continue
-		}
+// unlock releases the Library lock acquired by lock()
+func (l *Library) unlock() {
+	<-l.sem
+}
+
+// move runs an mtx media movement command.  If the command fails or
+// ctx is cancelled while it is running, the cached MediaInfo is
+// invalidated and a *StateUnknownError is returned, unless the command
+// was never started or the changer rejected it as an Illegal Request.
+func (l *Library) move(ctx context.Context, args ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := l.mtxCmd(ctx, args...)
+	switch {
+	case err == nil:
+		return nil
+	case notStarted(err):
+		return err
+	case ctx.Err() != nil:
+		l.update(func() { l.initialized = false })
+		return &StateUnknownError{Err: ctx.Err()}
+	case illegalRequestRxp.MatchString(err.Error()):
+		return err
+	}
+	l.update(func() { l.initialized = false })
+	return &StateUnknownError{Err: err}
+}
+
+// Status returns a structured representation of the drives, slots,
+// import/export, and media locations.  The MediaInfo is a snapshot that
+// later operations do not change, see Cached.
+func (l *Library) Status() (*MediaInfo, error) {
+	return l.StatusContext(context.Background())
+}
+
+// StatusContext is like Status but gives up if ctx is done before
+// the Library lock is acquired or before mtx completes
+func (l *Library) StatusContext(ctx context.Context) (*MediaInfo, error) {
+	if err := l.lock(ctx); err != nil {
+		return nil, errors.Wrap(err, "status")
 	}
-	if err := lscanner.Err(); err != nil {
-		return MediaInfo{}, err
+	defer l.unlock()
+	result, err := l.mtxCmd(ctx, "status")
+	if err != nil {
+		return nil, errors.Wrap(err, "status")
 	}
 
-	m := MediaInfo{
-		NumDrives:       driveCnt,
-		NumSlots:        slotCnt,
-		NumImportExport: mboxCnt,
-		Drives:          dmap,
-		Slots:           smap,
-		Mboxes:          mmap,
+	mi, err := parseStatus(bytes.NewReader(result))
+	if err == nil && !l.Lenient && mi.Warnings != nil {
+		err = mi.Warnings
+		mi.Warnings = nil
 	}
-	return m, nil
-
+	if err == nil && l.initialized {
+		mi.carryHomes(&l.mi)
+	}
+	l.update(func() {
+		l.mi = mi
+		l.initialized = err == nil
+		if l.initialized && l.em != nil {
+			l.em.assign(&l.mi)
+		}
+	})
+	return l.mi.clone(), errors.Wrap(err, "status")
 }
 
 // Inventory tells the Library to (re)inventory all the media
 // which usually involves a lot of robotic movement and barcode reading
 func (l *Library) Inventory() error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return l.InventoryContext(context.Background())
+}
 
-	_, err := mtxCmd(l.Command, l.Device, "inventory")
+// InventoryContext is like Inventory but gives up if ctx is done before
+// the Library lock is acquired or before mtx completes
+func (l *Library) InventoryContext(ctx context.Context) error {
+	if err := l.lock(ctx); err != nil {
+		return errors.Wrap(err, "inventory")
+	}
+	defer l.unlock()
+
+	_, err := l.mtxCmd(ctx, "inventory")
 	return errors.Wrap(err, "inventory")
 }
 
-// Load will attempt to load volume into specified drive
+// Load will attempt to load volume into specified drive from the
+// storage or mailbox element it is in.  A volume that is already in a
+// drive is refused, use Move to move it between drives.  vol may come
+// from any earlier snapshot, it is not modified.
 func (l *Library) Load(vol *Volume, drive Slot) error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return l.LoadContext(context.Background(), vol, drive)
+}
 
-	if l.mi.Drives[drive.ID].Vol != nil {
-		return errors.Errorf("attempting to load vol %v into non-epmty drive %v", vol.ID, drive.ID)
+// LoadContext is like Load but gives up if ctx is done before the
+// Library lock is acquired.  If ctx is done while the load is in
+// progress, an error with cause ErrStateUnknown is returned.
+func (l *Library) LoadContext(ctx context.Context, vol *Volume, drive Slot) error {
+	return l.LoadIf(ctx, vol, drive)
+}
+
+// LoadIf is like LoadContext but first checks conds against the
+// cached state.  If one does not hold, a *ConflictError is returned
+// without running mtx.
+func (l *Library) LoadIf(ctx context.Context, vol *Volume, drive Slot, conds ...Condition) error {
+	if err := l.lock(ctx); err != nil {
+		return errors.Wrap(err, "load")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("load", conds); err != nil {
+		return err
 	}
 
+	vol, err := l.current(vol)
+	if err != nil {
+		return errors.Wrap(err, "load")
+	}
+	if l.mi.Drives[drive.ID].Vol != nil {
+		return errors.Errorf("attempting to load vol %v into non-epmty drive %v", vol.name(), drive.ID)
+	}
 	if vol.Drive != "" {
-		return errors.Errorf("attempting to load vol %v that is already in drive %v", vol.ID, vol.Drive)
+		return errors.Errorf("attempting to load vol %v that is already in drive %v", vol.name(), vol.Drive)
 	}
-	_, err := mtxCmd(l.Command, l.Device, "load", vol.Home, drive.ID)
-	if err == nil && l.initialized {
-		d := l.mi.Drives[drive.ID]
-		l.mi.Drives[drive.ID] = Slot{
-			Type: d.Type,
-			ID:   d.ID,
-			Vol:  vol,
-		}
-		s := l.mi.Slots[vol.Home]
-		l.mi.Slots[vol.Home] = Slot{
-			Type: s.Type,
-			ID:   s.ID,
-		}
-		vol.Drive = drive.ID
+	if vol.Location == "" {
+		return errors.Errorf("attempting to load vol %v with unknown location", vol.name())
+	}
+	src, err := l.elementOf(vol)
+	if err != nil {
+		return errors.Wrap(err, "load")
 	}
+	drive.Type = DataTransferElement
+	err = l.transfer(ctx, src, drive)
 	return errors.Wrap(err, "load")
 }
 
 // LoadCln will attempt to move a randomized cleaning media to specified drive
 func (l *Library) LoadCln(d Slot) error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return l.LoadClnContext(context.Background(), d)
+}
+
+// LoadClnContext is like LoadCln but gives up if ctx is done before the
+// Library lock is acquired.  If ctx is done while the load is in
+// progress, an error with cause ErrStateUnknown is returned.
+func (l *Library) LoadClnContext(ctx context.Context, d Slot) error {
+	return l.LoadClnIf(ctx, d)
+}
+
+// LoadClnIf is like LoadClnContext but first checks conds against the
+// cached state.  If one does not hold, a *ConflictError is returned
+// without running mtx.
+func (l *Library) LoadClnIf(ctx context.Context, d Slot, conds ...Condition) error {
+	if err := l.lock(ctx); err != nil {
+		return errors.Wrap(err, "loadcln")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("loadcln", conds); err != nil {
+		return err
+	}
 
 	clns := FindCleaningMedia(l.mi)
 	if len(clns) == 0 {
@@ -313,74 +420,93 @@ func (l *Library) LoadCln(d Slot) error {
 	// Pick random cleaning media to load balance them
 	v := clns[rand.Intn(len(clns))]
 
-	_, err := mtxCmd(l.Command, l.Device, "load", v.Home, d.ID)
-	if err == nil && l.initialized {
-		d := l.mi.Drives[d.ID]
-		l.mi.Slots[v.Home].Vol.Drive = d.ID
-		l.mi.Drives[d.ID] = Slot{
-			Type: d.Type,
-			ID:   d.ID,
-			Vol:  l.mi.Slots[v.Home].Vol,
-		}
-		s := l.mi.Slots[v.Home]
-		l.mi.Slots[v.Home] = Slot{
-			Type: s.Type,
-			ID:   s.ID,
-		}
-	}
+	d.Type = DataTransferElement
+	err := l.transfer(ctx, l.storageSlot(v.Location), d)
 	return errors.Wrap(err, "loadcln")
 }
 
-// Unload will attempt to move volume from drive to home slot
+// Unload will attempt to move volume from drive to home slot.  vol may
+// come from any earlier snapshot, it is not modified.  See UnloadTo and
+// UnloadAnywhere if the home slot is full or unknown.
 func (l *Library) Unload(vol *Volume) error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return l.UnloadContext(context.Background(), vol)
+}
+
+// UnloadContext is like Unload but gives up if ctx is done before the
+// Library lock is acquired.  If ctx is done while the unload is in
+// progress, an error with cause ErrStateUnknown is returned.
+func (l *Library) UnloadContext(ctx context.Context, vol *Volume) error {
+	return l.UnloadIf(ctx, vol)
+}
+
+// UnloadIf is like UnloadContext but first checks conds against the
+// cached state.  If one does not hold, a *ConflictError is returned
+// without running mtx.
+func (l *Library) UnloadIf(ctx context.Context, vol *Volume, conds ...Condition) error {
+	if err := l.lock(ctx); err != nil {
+		return errors.Wrap(err, "unloadvol")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("unloadvol", conds); err != nil {
+		return err
+	}
 
+	vol, err := l.current(vol)
+	if err != nil {
+		return errors.Wrap(err, "unloadvol")
+	}
 	if vol.Drive == "" {
-		return errors.Errorf("attmepting to unload volume %v not currently in drive", vol.ID)
+		return errors.Errorf("attmepting to unload volume %v not currently in drive", vol.name())
 	}
 	if vol.Home == "" {
-		return errors.Errorf("no home slot found for volume %v, can't unlaod", vol.ID)
+		return errors.Errorf("no home slot found for volume %v, can't unlaod", vol.name())
 	}
 
-	_, err := mtxCmd(l.Command, l.Device, "unload", vol.Home, vol.Drive)
-	if err == nil && l.initialized {
-		s := l.mi.Slots[vol.Home]
-		l.mi.Slots[vol.Home] = Slot{
-			Type: s.Type,
-			ID:   s.ID,
-			Vol:  vol,
-		}
-		d := l.mi.Drives[vol.Drive]
-		l.mi.Drives[vol.Drive] = Slot{
-			Type: d.Type,
-			ID:   d.ID,
-		}
-		vol.Drive = ""
-	}
+	err = l.transfer(ctx, Slot{Type: DataTransferElement, ID: vol.Drive, Address: -1, Vol: vol}, l.storageSlot(vol.Home))
 	return errors.Wrap(err, "unloadvol")
 }
 
-// Transfer will attempt to move volume to specified slot
+// Transfer will attempt to move volume to specified slot, which may be
+// a storage, mailbox or drive element.  The home slot of the volume is
+// not changed.  vol may come from any earlier snapshot, it is not
+// modified.
 func (l *Library) Transfer(vol *Volume, slot Slot) error {
-	l.mu.Lock()
-	defer l.mu.Unlock()
+	return l.TransferContext(context.Background(), vol, slot)
+}
 
-	_, err := mtxCmd(l.Command, l.Device, "transfer", vol.ID, slot.ID)
-	if err == nil && l.initialized {
-		s := l.mi.Slots[slot.ID]
-		l.mi.Slots[slot.ID] = Slot{
-			Type: s.Type,
-			ID:   s.ID,
-			Vol:  vol,
-		}
-		s = l.mi.Slots[vol.Home]
-		l.mi.Slots[vol.Home] = Slot{
-			Type: s.Type,
-			ID:   s.ID,
-		}
-		vol.Home = slot.ID
+// TransferContext is like Transfer but gives up if ctx is done before
+// the Library lock is acquired.  If ctx is done while the transfer is
+// in progress, an error with cause ErrStateUnknown is returned.
+func (l *Library) TransferContext(ctx context.Context, vol *Volume, slot Slot) error {
+	return l.TransferIf(ctx, vol, slot)
+}
+
+// TransferIf is like TransferContext but first checks conds against the
+// cached state.  If one does not hold, a *ConflictError is returned
+// without running mtx.
+func (l *Library) TransferIf(ctx context.Context, vol *Volume, slot Slot, conds ...Condition) error {
+	if err := l.lock(ctx); err != nil {
+		return errors.Wrap(err, "transfer")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("transfer", conds); err != nil {
+		return err
 	}
+
+	vol, err := l.current(vol)
+	if err != nil {
+		return errors.Wrap(err, "transfer")
+	}
+	src, err := l.elementOf(vol)
+	if err != nil {
+		return errors.Wrap(err, "transfer")
+	}
+	if slot.Type == Unknown {
+		slot = l.storageSlot(slot.ID)
+	}
+	err = l.transfer(ctx, src, slot)
 	return errors.Wrap(err, "transfer")
 }
 
@@ -413,10 +539,11 @@ func GetMboxByID(id string, m MediaInfo) (Slot, error) {
 	return Slot{}, errors.Errorf("no slot found for id %v", id)
 }
 
-// GetEmptyDrives returns a slice of string drive IDs with no volumes set
+// GetEmptyDrives returns a slice of string drive IDs with no volumes set,
+// in numeric order
 func GetEmptyDrives(m MediaInfo) []string {
 	var result []string
-	for _, value := range m.Drives {
+	for _, value := range m.Drives.Sorted() {
 		if value.Vol == nil {
 			result = append(result, value.ID)
 		}
@@ -425,39 +552,41 @@ func GetEmptyDrives(m MediaInfo) []string {
 }
 
 // FindCleaningMedia returns a slice of Volumes that have serial
-// numbers begining with CLN that are not currently in a drive
+// numbers begining with CLN that are not currently in a drive, in
+// storage slot order
 func FindCleaningMedia(m MediaInfo) []Volume {
 	var result []Volume
-	for _, value := range m.Slots {
-		if value.Vol != nil {
-			if clnRxp.MatchString(value.Vol.ID) {
-				result = append(result, *value.Vol)
-			}
+	for _, v := range m.Volumes().InElements(StorageElement).Cleaning(true).All() {
+		result = append(result, *v)
+	}
+	return result
+}
+
+// FindUnlabeled returns the occupied drive, storage and mailbox slots
+// holding volumes without a readable barcode, in the order of AllElements
+func FindUnlabeled(mi *MediaInfo) []Slot {
+	var result []Slot
+	for slot := range mi.AllElements() {
+		if slot.Vol != nil && !slot.Vol.Labeled() {
+			result = append(result, slot)
 		}
 	}
 	return result
 }
 
-// FindStorageVolume returns a *Volume for the first matching
-// volume id in a storage slot
+// FindStorageVolume returns a *Volume for the matching volume id in a
+// storage slot, the lowest numbered slot if more than one matches
 func FindStorageVolume(barcode string, mi *MediaInfo) (*Volume, error) {
-	for _, slot := range mi.Slots {
-		if slot.Vol != nil && slot.Vol.ID == barcode {
-			return slot.Vol, nil
-		}
+	if v, ok := mi.Volumes().InElements(StorageElement).WithBarcode(barcode).First(); ok {
+		return v, nil
 	}
 	return nil, errors.Errorf("volume %s not found in any storage element slots", barcode)
 }
 
 // FindStorageVolumes returns a slice of *Volume for the matching
-// volume(s) with a given prefix id in a storage slot
+// volume(s) with a given prefix id in a storage slot, in slot order
 func FindStorageVolumes(prefix string, mi *MediaInfo) ([]*Volume, error) {
-	var result []*Volume
-	for _, slot := range mi.Slots {
-		if slot.Vol != nil && strings.HasPrefix(slot.Vol.ID, prefix) {
-			result = append(result, slot.Vol)
-		}
-	}
+	result := mi.Volumes().InElements(StorageElement).WithPrefix(prefix).All()
 	if len(result) == 0 {
 		return nil, errors.Errorf("no volumes with prefix %s found in any storage element slots", prefix)
 	}
@@ -465,44 +594,32 @@ func FindStorageVolumes(prefix string, mi *MediaInfo) ([]*Volume, error) {
 }
 
 // FindStorageVolumePattern returns a slice of *Volume for the matching
-// volume(s) with a given regex in a storage slot
+// volume(s) with a given regex in a storage slot, in slot order
 func FindStorageVolumePattern(pattern string, mi *MediaInfo) ([]*Volume, error) {
-	var result []*Volume
 	volregex, err := regexp.Compile(pattern)
 	if err != nil {
-		return result, errors.Wrap(err, "could not compile volume expression")
-	}
-	for _, slot := range mi.Slots {
-		if slot.Vol != nil && volregex.Match([]byte(slot.Vol.ID)) {
-			result = append(result, slot.Vol)
-		}
+		return nil, errors.Wrap(err, "could not compile volume expression")
 	}
+	result := mi.Volumes().InElements(StorageElement).Matching(volregex).All()
 	if len(result) == 0 {
 		return nil, errors.Errorf("no volumes with pattern %s found in any storage element slots", pattern)
 	}
 	return result, nil
 }
 
-// FindDriveVolume returns a *Volume for the first matching
-// volume id in a drive slot
+// FindDriveVolume returns a *Volume for the matching volume id in a
+// drive, the lowest numbered drive if more than one matches
 func FindDriveVolume(barcode string, mi *MediaInfo) (*Volume, error) {
-	for _, slot := range mi.Drives {
-		if slot.Vol != nil && slot.Vol.ID == barcode {
-			return slot.Vol, nil
-		}
+	if v, ok := mi.Volumes().InElements(DataTransferElement).WithBarcode(barcode).First(); ok {
+		return v, nil
 	}
 	return nil, errors.Errorf("volume %s not found in any drive element slots", barcode)
 }
 
 // FindDriveVolumes returns a slice of *Volume for the matching
-// volume(s) with a given prefix id in a storage slot
+// volume(s) with a given prefix id in a drive, in slot order
 func FindDriveVolumes(prefix string, mi *MediaInfo) ([]*Volume, error) {
-	var result []*Volume
-	for _, slot := range mi.Drives {
-		if slot.Vol != nil && strings.HasPrefix(slot.Vol.ID, prefix) {
-			result = append(result, slot.Vol)
-		}
-	}
+	result := mi.Volumes().InElements(DataTransferElement).WithPrefix(prefix).All()
 	if len(result) == 0 {
 		return nil, errors.Errorf("no volumes with prefix %s found in any drive element slots", prefix)
 	}
@@ -510,44 +627,32 @@ func FindDriveVolumes(prefix string, mi *MediaInfo) ([]*Volume, error) {
 }
 
 // FindDriveVolumePattern returns a slice of *Volume for the matching
-// volume(s) with a given regex in a storage slot
+// volume(s) with a given regex in a drive, in slot order
 func FindDriveVolumePattern(pattern string, mi *MediaInfo) ([]*Volume, error) {
-	var result []*Volume
 	volregex, err := regexp.Compile(pattern)
 	if err != nil {
-		return result, errors.Wrap(err, "could not compile volume expression")
-	}
-	for _, slot := range mi.Drives {
-		if slot.Vol != nil && volregex.Match([]byte(slot.Vol.ID)) {
-			result = append(result, slot.Vol)
-		}
+		return nil, errors.Wrap(err, "could not compile volume expression")
 	}
+	result := mi.Volumes().InElements(DataTransferElement).Matching(volregex).All()
 	if len(result) == 0 {
 		return nil, errors.Errorf("no volumes with pattern %s found in any drive element slots", pattern)
 	}
 	return result, nil
 }
 
-// FindMboxVolume returns a *Volume for the first matching
-// volume id in a mailbox slot
+// FindMboxVolume returns a *Volume for the matching volume id in a
+// mailbox slot, the lowest numbered slot if more than one matches
 func FindMboxVolume(barcode string, mi *MediaInfo) (*Volume, error) {
-	for _, slot := range mi.Mboxes {
-		if slot.Vol != nil && slot.Vol.ID == barcode {
-			return slot.Vol, nil
-		}
+	if v, ok := mi.Volumes().InElements(ImportExport).WithBarcode(barcode).First(); ok {
+		return v, nil
 	}
 	return nil, errors.Errorf("volume %s not found in any mailbox element slots", barcode)
 }
 
 // FindMboxVolumes returns a slice of *Volume for the matching
-// volume(s) with a given prefix id in a storage slot
+// volume(s) with a given prefix id in a mailbox slot, in slot order
 func FindMboxVolumes(prefix string, mi *MediaInfo) ([]*Volume, error) {
-	var result []*Volume
-	for _, slot := range mi.Mboxes {
-		if slot.Vol != nil && strings.HasPrefix(slot.Vol.ID, prefix) {
-			result = append(result, slot.Vol)
-		}
-	}
+	result := mi.Volumes().InElements(ImportExport).WithPrefix(prefix).All()
 	if len(result) == 0 {
 		return nil, errors.Errorf("no volumes with prefix %s found in any mailbox element slots", prefix)
 	}
@@ -555,18 +660,13 @@ func FindMboxVolumes(prefix string, mi *MediaInfo) ([]*Volume, error) {
 }
 
 // FindMboxVolumePattern returns a slice of *Volume for the matching
-// volume(s) with a given regex in a storage slot
+// volume(s) with a given regex in a mailbox slot, in slot order
 func FindMboxVolumePattern(pattern string, mi *MediaInfo) ([]*Volume, error) {
-	var result []*Volume
 	volregex, err := regexp.Compile(pattern)
 	if err != nil {
-		return result, errors.Wrap(err, "could not compile volume expression")
-	}
-	for _, slot := range mi.Mboxes {
-		if slot.Vol != nil && volregex.Match([]byte(slot.Vol.ID)) {
-			result = append(result, slot.Vol)
-		}
+		return nil, errors.Wrap(err, "could not compile volume expression")
 	}
+	result := mi.Volumes().InElements(ImportExport).Matching(volregex).All()
 	if len(result) == 0 {
 		return nil, errors.Errorf("no volumes with pattern %s found in any mailbox element slots", pattern)
 	}
@@ -575,42 +675,29 @@ func FindMboxVolumePattern(pattern string, mi *MediaInfo) ([]*Volume, error) {
 
 // FindHomeSlot returns the home slot for the volume
 func FindHomeSlot(vol Volume, mi MediaInfo) (Slot, error) {
-	if s, ok := mi.Slots[vol.Home]; ok {
-		return s, nil
-	}
-	if s, ok := mi.Mboxes[vol.Home]; ok {
+	if s, ok := mi.storageSlot(vol.Home); ok {
 		return s, nil
 	}
-	return Slot{}, errors.Errorf("no home slot found for volume %v", vol.ID)
+	return Slot{}, errors.Errorf("no home slot found for volume %v", vol.name())
 }
 
-func mtxCmd(mtxcmd, dev string, args ...string) ([]byte, error) {
-	cmdargs := append([]string{"-f", dev}, args...)
-	cmd := exec.Command(mtxcmd, cmdargs...)
-	stdout, err := cmd.StdoutPipe()
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		err = errors.Wrap(err, "mtx command setup stderr pipe")
-		return []byte{}, err
-	}
-	if err := cmd.Start(); err != nil {
-		err = errors.Wrap(err, "mtx start command")
-		return []byte{}, err
+// mtxCmd runs the mtx subcommand in args through the Library Executor,
+// folding any stderr output into the returned error
+func (l *Library) mtxCmd(ctx context.Context, args ...string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return []byte{}, errors.Wrap(err, "mtx command not started")
 	}
-	cmdout, err := ioutil.ReadAll(stdout)
-	if err != nil {
-		err = errors.Wrap(err, "mtx read stdout output")
-		return []byte{}, err
-	}
-	cmderr, err := ioutil.ReadAll(stderr)
+	return l.runCmd(ctx, l.executor(), args...)
+}
+
+// runCmd runs an mtx command line for the Library with e
+func (l *Library) runCmd(ctx context.Context, e Executor, args ...string) ([]byte, error) {
+	stdout, stderr, err := e.Run(ctx, l.Device, args...)
 	if err != nil {
-		err = errors.Wrap(err, "mtx read stderr output")
-		return []byte{}, err
-	}
-	if err := cmd.Wait(); err != nil {
-		err = errors.Wrap(err, "mtx wait command")
-		err = errors.Wrap(err, strings.TrimSuffix(string(cmderr), "\n"))
+		if msg := strings.TrimSuffix(string(stderr), "\n"); msg != "" {
+			err = errors.Wrap(err, msg)
+		}
 		return []byte{}, err
 	}
-	return cmdout, nil
+	return stdout, nil
 }
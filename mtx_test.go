@@ -1,9 +1,38 @@
 package mtx
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benmcclelland/mtx/mtxtest"
+	"github.com/pkg/errors"
+)
+
+// newSim returns a simulated changer in the state of mockStatus, with
+// faults injected
+func newSim(faults ...mtxtest.Fault) *mtxtest.Changer {
+	return mtxtest.New(mtxtest.Config{
+		Drives:       2,
+		Slots:        4,
+		ImportExport: 2,
+		Volumes: map[int]string{
+			1: "M00001L6",
+			3: "M00003L6",
+			4: "CLN004L6",
+			5: "M00002L6",
+		},
+		Loaded: map[int]int{0: 1},
+		Faults: faults,
+	})
+}
 
 func TestStatus(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("Status(): %v", err)
@@ -164,7 +193,7 @@ func TestStatus(t *testing.T) {
 }
 
 func TestInventory(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	err := lib.Inventory()
 	if err != nil {
 		t.Errorf("Inventory: %v", err)
@@ -172,34 +201,44 @@ func TestInventory(t *testing.T) {
 }
 
 func TestLoad(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	sim := newSim()
+	lib := NewLibraryExecutor("/dev/sga", sim)
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("Load: Status(): %v", err)
 	}
-	err = lib.Load(lib.mi.Slots["3"].Vol, lib.mi.Drives["1"])
+	err = lib.Load(m.Slots["3"].Vol, m.Drives["1"])
 	if err != nil {
 		t.Errorf("Load: %v", err)
 	}
-	// check changes to m
-	if m.Slots["3"].Vol != nil {
+	if m.Slots["3"].Vol == nil || m.Drives["1"].Vol != nil {
+		t.Error("Load: expected snapshot from Status unchanged")
+	}
+	c, ok := lib.Cached()
+	if !ok {
+		t.Fatalf("Load: Cached(): expected valid state")
+	}
+	if c.Slots["3"].Vol != nil {
 		t.Errorf("Load: expected to empty slot 3 (nil Vol), got %v",
-			m.Slots["3"].Vol.ID)
+			c.Slots["3"].Vol.ID)
 	}
-	if m.Drives["1"].Vol == nil {
+	if c.Drives["1"].Vol == nil {
 		t.Error("Load: expected non-empty Drive, got nil Vol")
 	}
-	if m.Drives["1"].Vol.ID != "M00003L6" {
+	if c.Drives["1"].Vol.ID != "M00003L6" {
 		t.Errorf("Load: expected Vol ID in drive expected M00003L6, got %v",
-			m.Drives["1"].Vol.ID)
+			c.Drives["1"].Vol.ID)
 	}
-	if m.Drives["1"].Vol.Drive != "1" {
-		t.Errorf("Load: expected Drive ID 1 for Vol M00003L6, got %v", m.Drives["1"].Vol.Drive)
+	if c.Drives["1"].Vol.Drive != "1" {
+		t.Errorf("Load: expected Drive ID 1 for Vol M00003L6, got %v", c.Drives["1"].Vol.Drive)
+	}
+	if sim.Slot(3).Full || sim.Drive(1).Tag != "M00003L6" {
+		t.Errorf("Load: expected changer to move M00003L6 to drive 1")
 	}
 }
 
 func TestLoadCln(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("LoadCln: Status(): %v", err)
@@ -208,77 +247,100 @@ func TestLoadCln(t *testing.T) {
 	if err != nil {
 		t.Errorf("LoadCln: LoadCln(): %v", err)
 	}
-	if m.Slots["4"].Vol != nil {
+	if m.Slots["4"].Vol == nil || m.Drives["1"].Vol != nil {
+		t.Error("LoadCln: expected snapshot from Status unchanged")
+	}
+	c, ok := lib.Cached()
+	if !ok {
+		t.Fatalf("LoadCln: Cached(): expected valid state")
+	}
+	if c.Slots["4"].Vol != nil {
 		t.Errorf("LoadCln: expected to empty slot 4 (nil Vol), got %v",
-			m.Slots["4"].Vol.ID)
+			c.Slots["4"].Vol.ID)
 	}
-	if m.Drives["1"].Vol == nil {
+	if c.Drives["1"].Vol == nil {
 		t.Error("LoadCln: expected non-empty Drive, got nil Vol")
 	}
-	if m.Drives["1"].Vol.ID != "CLN004L6" {
+	if c.Drives["1"].Vol.ID != "CLN004L6" {
 		t.Errorf("LoadCln: expected Vol ID in drive expected CLN004L6, got %v",
-			m.Drives["1"].Vol.ID)
+			c.Drives["1"].Vol.ID)
 	}
-	if m.Drives["1"].Vol.Drive != "1" {
-		t.Errorf("LoadCln: expected Drive ID 1 for Vol M00003L6, got %v", m.Drives["1"].Vol.Drive)
+	if c.Drives["1"].Vol.Drive != "1" {
+		t.Errorf("LoadCln: expected Drive ID 1 for Vol M00003L6, got %v", c.Drives["1"].Vol.Drive)
 	}
 }
 
 func TestUnLoad(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	sim := newSim()
+	lib := NewLibraryExecutor("/dev/sga", sim)
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("Unload: Status(): %v", err)
 	}
-	err = lib.Unload(lib.mi.Drives["0"].Vol)
+	err = lib.Unload(m.Drives["0"].Vol)
 	if err != nil {
 		t.Errorf("Unload: %v", err)
 	}
-	// check changes to m
-	if m.Slots["1"].Vol == nil {
+	if m.Slots["1"].Vol != nil || m.Drives["0"].Vol == nil || m.Drives["0"].Vol.Drive != "0" {
+		t.Error("Unload: expected snapshot from Status unchanged")
+	}
+	c, ok := lib.Cached()
+	if !ok {
+		t.Fatalf("Unload: Cached(): expected valid state")
+	}
+	if c.Slots["1"].Vol == nil {
 		t.Errorf("Unload: expected non-empty slot 1")
 	}
-	if m.Slots["1"].Vol.ID != "M00001L6" {
+	if c.Slots["1"].Vol.ID != "M00001L6" {
 		t.Errorf("Unload: Vol ID in slot expected M00001L6, got %v",
-			m.Slots["1"].Vol.ID)
+			c.Slots["1"].Vol.ID)
 	}
-	if m.Slots["1"].Vol.Drive != "" {
+	if c.Slots["1"].Vol.Drive != "" {
 		t.Errorf("Unload: Vol Drive in slot expected empty string, got %v",
-			m.Slots["1"].Vol.Drive)
+			c.Slots["1"].Vol.Drive)
 	}
-	if m.Drives["0"].Vol != nil {
-		t.Errorf("Unload: expected empty Drive, got Vol %v", m.Drives["0"].Vol.ID)
+	if c.Drives["0"].Vol != nil {
+		t.Errorf("Unload: expected empty Drive, got Vol %v", c.Drives["0"].Vol.ID)
+	}
+	if sim.Drive(0).Full || sim.Slot(1).Tag != "M00001L6" {
+		t.Errorf("Unload: expected changer to move M00001L6 to slot 1")
 	}
 }
 
 func TestTransfer(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("Unload: Status(): %v", err)
 	}
-	err = lib.Transfer(lib.mi.Slots["3"].Vol, lib.mi.Slots["2"])
+	err = lib.Transfer(m.Slots["3"].Vol, m.Slots["2"])
 	if err != nil {
 		t.Errorf("Unload: %v", err)
 	}
-	// check changes to m
-	if m.Slots["2"].Vol == nil {
+	if m.Slots["3"].Vol == nil || m.Slots["2"].Vol != nil || m.Slots["3"].Vol.Home != "3" {
+		t.Error("Transfer: expected snapshot from Status unchanged")
+	}
+	c, ok := lib.Cached()
+	if !ok {
+		t.Fatalf("Transfer: Cached(): expected valid state")
+	}
+	if c.Slots["2"].Vol == nil {
 		t.Errorf("Transfer: expected non-empty slot 1")
 	}
-	if m.Slots["2"].Vol.ID != "M00003L6" {
-		t.Errorf("Transfer: Vol ID in slot expected M00003L6, got %v", m.Slots["2"].Vol.ID)
+	if c.Slots["2"].Vol.ID != "M00003L6" {
+		t.Errorf("Transfer: Vol ID in slot expected M00003L6, got %v", c.Slots["2"].Vol.ID)
 	}
-	if m.Slots["2"].Vol.Drive != "" {
-		t.Errorf("Transfer: Vol Drive in slot expected empty string, got %v", m.Slots["2"].Vol.Drive)
+	if c.Slots["2"].Vol.Drive != "" {
+		t.Errorf("Transfer: Vol Drive in slot expected empty string, got %v", c.Slots["2"].Vol.Drive)
 	}
-	if m.Slots["3"].Vol != nil {
+	if c.Slots["3"].Vol != nil {
 		t.Errorf("Unload: expected empty Slot, got Vol %v\n%+v",
-			m.Slots["3"].Vol.ID, m.Slots)
+			c.Slots["3"].Vol.ID, c.Slots)
 	}
 }
 
 func TestStatusFail(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmockerr")
+	lib := NewLibraryExecutor("/dev/sga", newSim(mtxtest.Fault{Error: "command error!"}))
 	_, err := lib.Status()
 	if err == nil {
 		t.Errorf("Status(): expected error, got success")
@@ -286,7 +348,7 @@ func TestStatusFail(t *testing.T) {
 }
 
 func TestInventoryFail(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmockerr")
+	lib := NewLibraryExecutor("/dev/sga", newSim(mtxtest.Fault{Error: "command error!"}))
 	err := lib.Inventory()
 	if err == nil {
 		t.Errorf("Inventory(): expected error, got success")
@@ -294,7 +356,7 @@ func TestInventoryFail(t *testing.T) {
 }
 
 func TestLoadFail(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmockerr")
+	lib := NewLibraryExecutor("/dev/sga", newSim(mtxtest.Fault{Error: "command error!"}))
 	err := lib.Load(&Volume{ID: "ABC", Home: "1"},
 		Slot{Type: DataTransferElement, ID: "0"})
 	if err == nil {
@@ -303,7 +365,7 @@ func TestLoadFail(t *testing.T) {
 }
 
 func TestLoadClnFail(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmockerr")
+	lib := NewLibraryExecutor("/dev/sga", newSim(mtxtest.Fault{Error: "command error!"}))
 	err := lib.LoadCln(Slot{Type: DataTransferElement, ID: "0", Vol: nil})
 	if err == nil {
 		t.Errorf("LoadCln(): expected error, got success")
@@ -311,7 +373,7 @@ func TestLoadClnFail(t *testing.T) {
 }
 
 func TestUnloadFail(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmockerr")
+	lib := NewLibraryExecutor("/dev/sga", newSim(mtxtest.Fault{Error: "command error!"}))
 	err := lib.Unload(&Volume{ID: "ABC", Home: "1", Drive: "0"})
 	if err == nil {
 		t.Errorf("Unload(): expected error, got success")
@@ -319,7 +381,7 @@ func TestUnloadFail(t *testing.T) {
 }
 
 func TestTransferFail(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmockerr")
+	lib := NewLibraryExecutor("/dev/sga", newSim(mtxtest.Fault{Error: "command error!"}))
 	err := lib.Transfer(&Volume{ID: "ABC", Home: "1"},
 		Slot{Type: StorageElement, ID: "2"})
 	if err == nil {
@@ -328,7 +390,7 @@ func TestTransferFail(t *testing.T) {
 }
 
 func TestGetDriveByID(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("GetDriveByID: Status(): %v", err)
@@ -343,7 +405,7 @@ func TestGetDriveByID(t *testing.T) {
 }
 
 func TestGetDriveByIDFail(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("GetDriveByID: Status(): %v", err)
@@ -355,7 +417,7 @@ func TestGetDriveByIDFail(t *testing.T) {
 }
 
 func TestGetSlotByID(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("GetSlotByID: Status(): %v", err)
@@ -370,7 +432,7 @@ func TestGetSlotByID(t *testing.T) {
 }
 
 func TestGetSlotByIDFail(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("GetSlotByID: Status(): %v", err)
@@ -382,7 +444,7 @@ func TestGetSlotByIDFail(t *testing.T) {
 }
 
 func TestGetMboxByID(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("GetMboxByID: Status(): %v", err)
@@ -397,7 +459,7 @@ func TestGetMboxByID(t *testing.T) {
 }
 
 func TestGetMboxByIDFail(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("GetMboxByID: Status(): %v", err)
@@ -409,7 +471,7 @@ func TestGetMboxByIDFail(t *testing.T) {
 }
 
 func TestFindHomeSlot(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("FindHomeSlot: Status(): %v", err)
@@ -424,7 +486,7 @@ func TestFindHomeSlot(t *testing.T) {
 }
 
 func TestFindHomeSlotFail(t *testing.T) {
-	lib := NewLibraryCmd("/dev/sga", "./mtxmock")
+	lib := NewLibraryExecutor("/dev/sga", newSim())
 	m, err := lib.Status()
 	if err != nil {
 		t.Errorf("FindHomeSlot: Status(): %v", err)
@@ -434,3 +496,362 @@ func TestFindHomeSlotFail(t *testing.T) {
 		t.Errorf("FindHomeSlot(): expected error, but got nil")
 	}
 }
+
+func TestStatusContextCancelBeforeStart(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", newSim())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := lib.StatusContext(ctx)
+	if errors.Cause(err) != context.Canceled {
+		t.Errorf("StatusContext(): expected context.Canceled, got %v", err)
+	}
+}
+
+func TestLoadContextCancelWaitingForLock(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", newSim())
+	_, err := lib.Status()
+	if err != nil {
+		t.Errorf("LoadContext: Status(): %v", err)
+	}
+	if err := lib.lock(context.Background()); err != nil {
+		t.Fatalf("lock(): %v", err)
+	}
+	defer lib.unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = lib.LoadContext(ctx, lib.mi.Slots["3"].Vol, lib.mi.Drives["1"])
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("LoadContext(): expected context.DeadlineExceeded, got %v", err)
+	}
+	if lib.mi.Slots["3"].Vol == nil {
+		t.Errorf("LoadContext(): expected slot 3 unchanged after failed lock")
+	}
+}
+
+func TestLoadContextCancelDuringMove(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", newSim(mtxtest.Fault{Command: "load", Hang: true}))
+	_, err := lib.Status()
+	if err != nil {
+		t.Errorf("LoadContext: Status(): %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = lib.LoadContext(ctx, lib.mi.Slots["3"].Vol, lib.mi.Drives["1"])
+	if errors.Cause(err) != ErrStateUnknown {
+		t.Errorf("LoadContext(): expected ErrStateUnknown, got %v", err)
+	}
+	if lib.initialized {
+		t.Errorf("LoadContext(): expected cached MediaInfo to be invalidated")
+	}
+}
+
+func TestStatusContextTimeout(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", newSim(mtxtest.Fault{Command: "status", Hang: true}))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := lib.StatusContext(ctx)
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("StatusContext(): expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+const unlabeledStatus = `  Storage Changer /dev/sga:4 Drives, 7 Slots ( 2 Import/Export )
+Data Transfer Element 0:Full (Storage Element 1 Loaded):VolumeTag = M00001L6
+Data Transfer Element 1:Full (Storage Element 2 Loaded)
+Data Transfer Element 2:Full (Unknown Storage Element Loaded):VolumeTag = M00009L6
+Data Transfer Element 3:Full (Unknown Storage Element Loaded)
+      Storage Element 1:Empty
+      Storage Element 2:Empty
+      Storage Element 3:Full :VolumeTag=M00003L6
+      Storage Element 4:Full 
+      Storage Element 5:Full
+      Storage Element 6 IMPORT/EXPORT:Full 
+      Storage Element 7 IMPORT/EXPORT:Full :VolumeTag=M00007L6
+`
+
+func TestStatusUnlabeled(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: unlabeledStatus}))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	tests := []struct {
+		name  string
+		slot  Slot
+		id    string
+		home  string
+		drive string
+	}{
+		{"drive 0", m.Drives["0"], "M00001L6", "1", "0"},
+		{"drive 1", m.Drives["1"], "", "2", "1"},
+		{"drive 2", m.Drives["2"], "M00009L6", "", "2"},
+		{"drive 3", m.Drives["3"], "", "", "3"},
+		{"slot 3", m.Slots["3"], "M00003L6", "3", ""},
+		{"slot 4", m.Slots["4"], "", "4", ""},
+		{"slot 5", m.Slots["5"], "", "5", ""},
+		{"mbox 6", m.Mboxes["6"], "", "6", ""},
+		{"mbox 7", m.Mboxes["7"], "M00007L6", "7", ""},
+	}
+	for _, tt := range tests {
+		v := tt.slot.Vol
+		if v == nil {
+			t.Errorf("Status(): %v expected occupied, got empty", tt.name)
+			continue
+		}
+		if v.ID != tt.id || v.Home != tt.home || v.Drive != tt.drive {
+			t.Errorf("Status(): %v expected {%v %v %v}, got %+v",
+				tt.name, tt.id, tt.home, tt.drive, *v)
+		}
+	}
+	if n := len(FindUnlabeled(m)); n != 5 {
+		t.Errorf("FindUnlabeled(): expected 5 slots, got %v", n)
+	}
+	if _, err := FindStorageVolume("", m); err == nil {
+		t.Errorf("FindStorageVolume(\"\"): expected unlabeled volumes not to match")
+	}
+	if vols, _ := FindStorageVolumes("", m); len(vols) != 1 {
+		t.Errorf("FindStorageVolumes(\"\"): expected only labeled volumes, got %v", len(vols))
+	}
+	if vols, _ := FindDriveVolumePattern(".*", m); len(vols) != 2 {
+		t.Errorf("FindDriveVolumePattern(\".*\"): expected only labeled volumes, got %v", len(vols))
+	}
+}
+
+func TestUnlabeledLoadUnload(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: unlabeledStatus}))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	// drives holding unlabeled media are not free
+	if err := lib.Load(m.Slots["3"].Vol, m.Drives["1"]); err == nil {
+		t.Errorf("Load(): expected error loading into drive with unlabeled media")
+	}
+	if err := lib.Unload(m.Drives["3"].Vol); err == nil {
+		t.Errorf("Unload(): expected error for volume with unknown home")
+	}
+	if err := lib.Load(&Volume{ID: "M00009L6"}, Slot{Type: DataTransferElement, ID: "9"}); err == nil {
+		t.Errorf("Load(): expected error for volume with unknown location")
+	}
+}
+
+const altTagStatus = `  Storage Changer /dev/sga:2 Drives, 5 Slots ( 1 Import/Export )
+Data Transfer Element 0:Full (Storage Element 1 Loaded):VolumeTag = M00001L6                        :AlternateVolumeTag = A00001
+Data Transfer Element 1:Full (Storage Element 2 Loaded):VolumeTag = TAPE 02   
+      Storage Element 1:Empty
+      Storage Element 2:Empty
+      Storage Element 3:Full :VolumeTag=M00003L6                        :AlternateVolumeTag=A00003                          
+      Storage Element 4:Full :VolumeTag=  WITH SPACE 4  
+      Storage Element 5 IMPORT/EXPORT:Full :VolumeTag=M00005L6:AlternateVolumeTag=A 00005
+`
+
+func TestStatusAltTags(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: altTagStatus}))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	tests := []struct {
+		name  string
+		slot  Slot
+		id    string
+		altid string
+	}{
+		{"drive 0", m.Drives["0"], "M00001L6", "A00001"},
+		{"drive 1", m.Drives["1"], "TAPE 02", ""},
+		{"slot 3", m.Slots["3"], "M00003L6", "A00003"},
+		{"slot 4", m.Slots["4"], "WITH SPACE 4", ""},
+		{"mbox 5", m.Mboxes["5"], "M00005L6", "A 00005"},
+	}
+	for _, tt := range tests {
+		v := tt.slot.Vol
+		if v == nil {
+			t.Errorf("Status(): %v expected occupied, got empty", tt.name)
+			continue
+		}
+		if v.ID != tt.id || v.AltID != tt.altid {
+			t.Errorf("Status(): %v expected ID %q AltID %q, got %q %q",
+				tt.name, tt.id, tt.altid, v.ID, v.AltID)
+		}
+	}
+	if v, err := FindStorageVolume("A00003", m); err != nil || v.ID != "M00003L6" {
+		t.Errorf("FindStorageVolume(A00003): expected M00003L6, got %v %v", v, err)
+	}
+	if v, err := FindStorageVolume("WITH SPACE 4", m); err != nil || v.Home != "4" {
+		t.Errorf("FindStorageVolume(WITH SPACE 4): expected slot 4, got %v %v", v, err)
+	}
+	if vols, err := FindDriveVolumes("A0", m); err != nil || len(vols) != 1 {
+		t.Errorf("FindDriveVolumes(A0): expected 1 volume, got %v %v", len(vols), err)
+	}
+	if vols, err := FindMboxVolumePattern("^A [0-9]+$", m); err != nil || len(vols) != 1 {
+		t.Errorf("FindMboxVolumePattern(): expected 1 volume, got %v %v", len(vols), err)
+	}
+}
+
+const quirkStatus = `  Storage Changer /dev/sga:2 Drives, 4 Slots ( 1 Import/Export )
+Data Transfer Element 0:Empty
+Data Transfer Element 1:Empty
+      Storage Element 1:Empty
+      Storage Element 2:Full :VolumeTag=M00002L6
+      Storage Element 3:Reserved
+
+      Storage Element 4 IMPORT/EXPORT:Empty
+`
+
+func TestStatusStrict(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: quirkStatus}))
+	_, err := lib.Status()
+	perr, ok := errors.Cause(err).(*ParseError)
+	if !ok {
+		t.Fatalf("Status(): expected *ParseError, got %v", err)
+	}
+	if len(perr.Lines) != 1 || perr.Lines[0].Num != 6 ||
+		perr.Lines[0].Text != "      Storage Element 3:Reserved" {
+		t.Errorf("Status(): unexpected unrecognized lines %+v", perr.Lines)
+	}
+	if perr.ExpectedSlots != 3 || perr.FoundSlots != 2 {
+		t.Errorf("Status(): expected 3 slots found 2, got %v found %v",
+			perr.ExpectedSlots, perr.FoundSlots)
+	}
+	if perr.ExpectedDrives != perr.FoundDrives || perr.ExpectedMboxes != perr.FoundMboxes {
+		t.Errorf("Status(): unexpected count mismatch %+v", perr)
+	}
+	want := `parse status: line 6: unrecognized "      Storage Element 3:Reserved"; expected 3 slots, found 2`
+	if perr.Error() != want {
+		t.Errorf("Status(): expected %q, got %q", want, perr.Error())
+	}
+	if lib.initialized {
+		t.Errorf("Status(): expected cache not initialized after strict failure")
+	}
+}
+
+func TestStatusLenient(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: quirkStatus},
+		Step{Args: []string{"status"}, Stdout: mockStatus}))
+	lib.Lenient = true
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if m.Warnings == nil || len(m.Warnings.Lines) != 1 || m.Warnings.FoundSlots != 2 {
+		t.Errorf("Status(): expected warnings, got %+v", m.Warnings)
+	}
+	if m.Slots["2"].Vol == nil {
+		t.Errorf("Status(): expected slot 2 parsed in lenient mode")
+	}
+	m, err = lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if m.Warnings != nil {
+		t.Errorf("Status(): expected no warnings, got %v", m.Warnings)
+	}
+}
+
+func TestParseStatusFunc(t *testing.T) {
+	var got []string
+	err := ParseStatusFunc(strings.NewReader(mockStatus), func(s Slot) error {
+		got = append(got, s.Type.String()+" "+s.ID)
+		if s.Type == DataTransferElement && s.Vol != nil && s.Vol.Drive != s.ID {
+			t.Errorf("ParseStatusFunc(): drive %v volume has Drive %q", s.ID, s.Vol.Drive)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ParseStatusFunc(): %v", err)
+	}
+	want := "drive 0,drive 1,storage 1,storage 2,storage 3,storage 4,mailbox 5,mailbox 6"
+	if strings.Join(got, ",") != want {
+		t.Errorf("ParseStatusFunc(): expected %v, got %v", want, strings.Join(got, ","))
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = ParseStatusFunc(strings.NewReader(mockStatus), func(s Slot) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("ParseStatusFunc(): expected stop after 1 element, got %v after %v", err, n)
+	}
+
+	err = ParseStatusFunc(strings.NewReader(quirkStatus), func(Slot) error { return nil })
+	if _, ok := err.(*ParseError); !ok {
+		t.Errorf("ParseStatusFunc(): expected *ParseError, got %v", err)
+	}
+}
+
+// largeStatus returns status output for a library with n elements, one
+// percent of them drives and one percent mailbox slots
+func largeStatus(n int) []byte {
+	drives, mboxes := n/100, n/100
+	slots := n - drives - mboxes
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "  Storage Changer /dev/sg0:%d Drives, %d Slots ( %d Import/Export )\n",
+		drives, slots+mboxes, mboxes)
+	for i := 0; i < drives; i++ {
+		if i%2 == 0 {
+			fmt.Fprintf(&b, "Data Transfer Element %d:Empty\n", i)
+			continue
+		}
+		fmt.Fprintf(&b, "Data Transfer Element %d:Full (Storage Element %d Loaded):VolumeTag = L%05dL6\n",
+			i, i+1, i+1)
+	}
+	for i := 1; i <= slots+mboxes; i++ {
+		ie := ""
+		if i > slots {
+			ie = " IMPORT/EXPORT"
+		}
+		if i <= drives && i%2 == 0 || i%10 == 0 {
+			fmt.Fprintf(&b, "      Storage Element %d%s:Empty\n", i, ie)
+			continue
+		}
+		fmt.Fprintf(&b, "      Storage Element %d%s:Full :VolumeTag=L%05dL6\n", i, ie, i)
+	}
+	return b.Bytes()
+}
+
+// reportPerElement reports the time and allocations per element of a
+// benchmark that parses n elements per iteration
+func reportPerElement(b *testing.B, n int, f func()) {
+	var before, after runtime.MemStats
+	b.ReportAllocs()
+	b.ResetTimer()
+	runtime.ReadMemStats(&before)
+	for i := 0; i < b.N; i++ {
+		f()
+	}
+	runtime.ReadMemStats(&after)
+	b.StopTimer()
+	elements := float64(b.N) * float64(n)
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/elements, "ns/element")
+	b.ReportMetric(float64(after.Mallocs-before.Mallocs)/elements, "allocs/element")
+}
+
+func BenchmarkParseStatus10k(b *testing.B) {
+	const n = 10000
+	out := largeStatus(n)
+	b.SetBytes(int64(len(out)))
+	reportPerElement(b, n, func() {
+		if _, err := parseStatus(bytes.NewReader(out)); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkParseStatusFunc10k(b *testing.B) {
+	const n = 10000
+	out := largeStatus(n)
+	b.SetBytes(int64(len(out)))
+	reportPerElement(b, n, func() {
+		err := ParseStatusFunc(bytes.NewReader(out), func(Slot) error { return nil })
+		if err != nil {
+			b.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package mtxtest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Fault is a fault injection rule for a Changer.  A Fault matches
+// commands by subcommand and arguments, and when it fires it may delay
+// the command, hang it, hide volume tags in status output, or fail it
+// with an mtx style error message or SCSI sense report.
+type Fault struct {
+	// Command is the mtx subcommand to match, "" matches any command
+	Command string `json:"command,omitempty"`
+	// Args are the arguments after the subcommand to match, "*"
+	// matches any single argument and nil matches any arguments.
+	// For example Command "load" and Args {"*", "1"} matches every
+	// load into drive 1.
+	Args []string `json:"args,omitempty"`
+	// Nth fires the fault only on the Nth matching command (1 based),
+	// 0 fires on every matching command
+	Nth int `json:"nth,omitempty"`
+	// Seen is the number of commands matched so far
+	Seen int `json:"seen,omitempty"`
+
+	// Delay slows the command down by this long before it runs
+	Delay time.Duration `json:"delay,omitempty"`
+	// Hang blocks the command until it is cancelled, or forever
+	Hang bool `json:"hang,omitempty"`
+	// HideTags are storage element numbers reported as "Full" without
+	// a VolumeTag in status output, as for an unreadable barcode
+	HideTags []int `json:"hide_tags,omitempty"`
+	// Error fails the command with this message on stderr
+	Error string `json:"error,omitempty"`
+	// Sense fails the command with a SCSI sense report on stderr
+	Sense *Sense `json:"sense,omitempty"`
+	// AfterMove carries out the command before failing it, as when
+	// the robot completes a move but reports an error anyway
+	AfterMove bool `json:"after_move,omitempty"`
+}
+
+// Sense is the SCSI sense data reported by a failing command
+type Sense struct {
+	Key  byte `json:"key"`
+	ASC  byte `json:"asc"`
+	ASCQ byte `json:"ascq"`
+}
+
+var senseKeys = []string{
+	"No Sense",
+	"Recovered Error",
+	"Not Ready",
+	"Medium Error",
+	"Hardware Error",
+	"Illegal Request",
+	"Unit Attention",
+	"Data Protect",
+	"Blank Check",
+	"Vendor Specific",
+	"Copy Aborted",
+	"Aborted Command",
+	"Equal",
+	"Volume Overflow",
+	"Miscompare",
+	"Reserved",
+}
+
+// String is the sense report as mtx prints it to stderr
+func (s Sense) String() string {
+	var b strings.Builder
+	p := func(format string, a ...interface{}) {
+		fmt.Fprintf(&b, "mtx: Request Sense: "+format+"\n", a...)
+	}
+	p("Long Report=yes")
+	p("Valid Residual=no")
+	p("Error Code=70 (Current)")
+	p("Sense Key=%s", senseKeys[s.Key&0x0f])
+	p("FileMark=no")
+	p("EOM=no")
+	p("ILI=no")
+	p("Additional Sense Code = %02X", s.ASC)
+	p("Additional Sense Qualifier = %02X", s.ASCQ)
+	p("BCD=no")
+	p("Reserved=no")
+	p("Sense Key Specific=no")
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (f *Fault) matches(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	if f.Command != "" && f.Command != args[0] {
+		return false
+	}
+	if f.Args == nil {
+		return true
+	}
+	if len(f.Args) != len(args)-1 {
+		return false
+	}
+	for i, a := range f.Args {
+		if a != "*" && a != args[i+1] {
+			return false
+		}
+	}
+	return true
+}
+
+// trigger counts the command against every matching Fault and returns
+// a copy of the first one that fires, or nil
+func (c *Changer) trigger(args []string) *Fault {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var fired *Fault
+	for i := range c.faults {
+		f := &c.faults[i]
+		if !f.matches(args) {
+			continue
+		}
+		f.Seen++
+		if fired == nil && (f.Nth == 0 || f.Nth == f.Seen) {
+			cp := *f
+			fired = &cp
+		}
+	}
+	return fired
+}
+
+// wait applies any Delay or Hang of the Fault
+func (f *Fault) wait(ctx context.Context) error {
+	if f.Delay > 0 {
+		t := time.NewTimer(f.Delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if f.Hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (f *Fault) fails() bool {
+	return f.Error != "" || f.Sense != nil
+}
+
+func (f *Fault) hides(n int) bool {
+	if f == nil {
+		return false
+	}
+	for _, h := range f.HideTags {
+		if h == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Fault) err(args []string) error {
+	if f.Sense != nil {
+		return errors.Errorf("%v\n%s Failed", f.Sense, strings.ToUpper(args[0]))
+	}
+	return errors.New(f.Error)
+}
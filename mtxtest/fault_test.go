@@ -0,0 +1,151 @@
+package mtxtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benmcclelland/mtx"
+	"github.com/pkg/errors"
+)
+
+func TestFaultNth(t *testing.T) {
+	c := newMock()
+	c.AddFault(Fault{Command: "status", Nth: 2, Error: "status failed"})
+	for i := 1; i <= 3; i++ {
+		_, stderr, err := c.Run(context.Background(), "/dev/sga", "status")
+		if (i == 2) != (err != nil) {
+			t.Errorf("status %v: unexpected result %v", i, err)
+		}
+		if i == 2 && string(stderr) != "status failed\n" {
+			t.Errorf("status %v: unexpected stderr %q", i, stderr)
+		}
+	}
+}
+
+func TestFaultDrive(t *testing.T) {
+	c := newMock()
+	c.AddFault(Fault{Command: "load", Args: []string{"*", "1"}, Error: "Drive 1 door open"})
+	lib := mtx.NewLibraryExecutor("/dev/sga", c)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	vol := m.Slots["3"].Vol
+	err = lib.Load(vol, m.Drives["1"])
+	if errors.Cause(err) != mtx.ErrStateUnknown || !strings.Contains(err.Error(), "Drive 1 door open") {
+		t.Fatalf("Load(): expected ErrStateUnknown with injected failure, got %v", err)
+	}
+	// the library cannot tell if the robot moved, so the cache is dropped
+	if _, ok := lib.Cached(); ok {
+		t.Errorf("Load(): expected cache invalidated after failed load")
+	}
+	if c.Slot(3).Tag != "M00003L6" || vol.Drive != "" {
+		t.Errorf("Load(): changer state changed after failed load")
+	}
+}
+
+func TestFaultIllegalRequest(t *testing.T) {
+	c := newMock()
+	c.AddFault(Fault{Command: "load", Sense: &Sense{Key: 5, ASC: 0x3b, ASCQ: 0x0e}})
+	lib := mtx.NewLibraryExecutor("/dev/sga", c)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	err = lib.Load(m.Slots["3"].Vol, m.Drives["1"])
+	if err == nil || errors.Cause(err) == mtx.ErrStateUnknown {
+		t.Fatalf("Load(): expected rejected load, got %v", err)
+	}
+	// a rejected command did not move anything, so the cache is kept
+	cached, ok := lib.Cached()
+	if !ok || cached.Slots["3"].Vol == nil || cached.Drives["1"].Vol != nil {
+		t.Errorf("Load(): cache changed after rejected load: %v", cached)
+	}
+}
+
+func TestFaultAfterMove(t *testing.T) {
+	c := newMock()
+	c.AddFault(Fault{Command: "unload", AfterMove: true, Error: "Unload Failed"})
+	lib := mtx.NewLibraryExecutor("/dev/sga", c)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Unload(m.Drives["0"].Vol); errors.Cause(err) != mtx.ErrStateUnknown {
+		t.Fatalf("Unload(): expected ErrStateUnknown, got %v", err)
+	}
+	// the robot moved the tape even though mtx reported a failure, so
+	// the cache must not keep claiming it is in the drive
+	if _, ok := lib.Cached(); ok {
+		t.Errorf("Unload(): expected cache invalidated after failed unload")
+	}
+	if _, err := lib.UnloadAnywhere(m.Drives["0"].Vol, mtx.LowestSlot); err == nil {
+		t.Errorf("UnloadAnywhere(): expected error without cached state")
+	}
+	m, err = lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if m.Drives["0"].Vol != nil || m.Slots["1"].Vol == nil {
+		t.Errorf("Status(): expected resync to show tape in slot 1")
+	}
+}
+
+func TestFaultSense(t *testing.T) {
+	c := newMock()
+	c.AddFault(Fault{Command: "transfer", Sense: &Sense{Key: 5, ASC: 0x3b, ASCQ: 0x0e}})
+	_, stderr, err := c.Run(context.Background(), "/dev/sga", "transfer", "3", "2")
+	if err == nil {
+		t.Fatalf("transfer: expected injected failure")
+	}
+	for _, want := range []string{
+		"mtx: Request Sense: Sense Key=Illegal Request\n",
+		"mtx: Request Sense: Additional Sense Code = 3B\n",
+		"mtx: Request Sense: Additional Sense Qualifier = 0E\n",
+		"TRANSFER Failed\n",
+	} {
+		if !strings.Contains(string(stderr), want) {
+			t.Errorf("transfer: expected %q in stderr:\n%s", want, stderr)
+		}
+	}
+}
+
+func TestFaultHideTags(t *testing.T) {
+	c := newMock()
+	c.AddFault(Fault{Command: "status", HideTags: []int{3}})
+	out, _, err := c.Run(context.Background(), "/dev/sga", "status")
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if !strings.Contains(string(out), "      Storage Element 3:Full \n") {
+		t.Errorf("status: expected slot 3 without VolumeTag, got\n%s", out)
+	}
+}
+
+func TestFaultDelayHang(t *testing.T) {
+	c := newMock()
+	c.AddFault(Fault{Command: "inventory", Delay: 20 * time.Millisecond})
+	c.AddFault(Fault{Command: "load", Hang: true})
+	lib := mtx.NewLibraryExecutor("/dev/sga", c)
+
+	start := time.Now()
+	if err := lib.Inventory(); err != nil {
+		t.Errorf("Inventory(): %v", err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Errorf("Inventory(): expected delayed command")
+	}
+
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = lib.LoadContext(ctx, m.Slots["3"].Vol, m.Drives["1"])
+	if errors.Cause(err) != mtx.ErrStateUnknown {
+		t.Errorf("LoadContext(): expected ErrStateUnknown, got %v", err)
+	}
+}
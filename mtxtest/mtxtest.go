@@ -0,0 +1,494 @@
+/*
+Package mtxtest provides a stateful in-memory SCSI media changer that
+behaves like the mtx executable.  A Changer satisfies the mtx.Executor
+interface so a Library can be driven entirely from Go tests:
+
+	c := mtxtest.New(mtxtest.Config{
+		Drives:       2,
+		Slots:        4,
+		ImportExport: 2,
+		Volumes:      map[int]string{3: "M00003L6", 5: "M00005L6"},
+	})
+	lib := mtx.NewLibraryExecutor("/dev/sga", c)
+
+Element numbering follows mtx: drives are numbered from 0, storage
+elements from 1, and import/export elements follow the storage slots.
+*/
+package mtxtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Element addresses reported by loaderinfo, laid out the way many
+// vendors assign them
+const (
+	FirstTransportAddress    = 0x0000
+	FirstImportExportAddress = 0x0010
+	FirstDriveAddress        = 0x0100
+	FirstSlotAddress         = 0x1000
+)
+
+// Config describes the layout and initial contents of a Changer
+type Config struct {
+	// Drives is the number of data transfer elements
+	Drives int
+	// Slots is the number of storage elements
+	Slots int
+	// ImportExport is the number of import/export elements
+	ImportExport int
+	// Volumes maps storage element numbers (1 based, import/export
+	// elements included) to the barcode of the media in them
+	Volumes map[int]string
+	// Loaded maps drive numbers to the storage element number of a
+	// volume from Volumes that starts out loaded in the drive
+	Loaded map[int]int
+	// Faults are injected into matching commands, see Fault
+	Faults []Fault
+}
+
+// Element is the state of a single element in the Changer
+type Element struct {
+	// Full is true if the element holds media
+	Full bool `json:"full,omitempty"`
+	// Tag is the barcode of the media, "" if unlabeled
+	Tag string `json:"tag,omitempty"`
+	// AltTag is the alternate volume tag, "" if none
+	AltTag string `json:"alt_tag,omitempty"`
+	// Src is the storage element a drive was loaded from, or 0
+	// for storage elements and unknown sources
+	Src int `json:"src,omitempty"`
+}
+
+// State is a serializable snapshot of a Changer
+type State struct {
+	// Drives are the data transfer elements in drive number order
+	Drives []Element `json:"drives"`
+	// Slots are the storage elements in element number order,
+	// with the import/export elements last
+	Slots []Element `json:"slots"`
+	// ImportExport is the number of import/export elements
+	ImportExport int `json:"import_export"`
+	// Faults are the fault injection rules and their match counts
+	Faults []Fault `json:"faults,omitempty"`
+}
+
+// Changer is a simulated media changer.  It is safe for concurrent use.
+type Changer struct {
+	mu     sync.Mutex
+	drives []Element
+	slots  []Element
+	ie     int
+	faults []Fault
+}
+
+// New returns a Changer configured by c.  It panics if c references
+// elements that do not exist, since that is always a test bug.
+func New(c Config) *Changer {
+	ch := &Changer{
+		drives: make([]Element, c.Drives),
+		slots:  make([]Element, c.Slots+c.ImportExport),
+		ie:     c.ImportExport,
+		faults: append([]Fault(nil), c.Faults...),
+	}
+	for n, tag := range c.Volumes {
+		if n < 1 || n > len(ch.slots) {
+			panic(fmt.Sprintf("mtxtest: no storage element %d", n))
+		}
+		ch.slots[n-1] = Element{Full: true, Tag: tag}
+	}
+	for d, n := range c.Loaded {
+		if d < 0 || d >= len(ch.drives) {
+			panic(fmt.Sprintf("mtxtest: no drive %d", d))
+		}
+		if n < 1 || n > len(ch.slots) || !ch.slots[n-1].Full {
+			panic(fmt.Sprintf("mtxtest: no volume in storage element %d", n))
+		}
+		ch.drives[d] = Element{Full: true, Tag: ch.slots[n-1].Tag, AltTag: ch.slots[n-1].AltTag, Src: n}
+		ch.slots[n-1] = Element{}
+	}
+	return ch
+}
+
+// FromState returns a Changer restored from a State snapshot
+func FromState(s State) *Changer {
+	ch := &Changer{
+		drives: append([]Element(nil), s.Drives...),
+		slots:  append([]Element(nil), s.Slots...),
+		ie:     s.ImportExport,
+		faults: append([]Fault(nil), s.Faults...),
+	}
+	if ch.ie > len(ch.slots) {
+		ch.ie = len(ch.slots)
+	}
+	return ch
+}
+
+// State returns a snapshot of the Changer
+func (c *Changer) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return State{
+		Drives:       append([]Element(nil), c.drives...),
+		Slots:        append([]Element(nil), c.slots...),
+		ImportExport: c.ie,
+		Faults:       append([]Fault(nil), c.faults...),
+	}
+}
+
+// AddFault adds a fault injection rule to the Changer
+func (c *Changer) AddFault(f Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = append(c.faults, f)
+}
+
+// Drive returns the state of drive d
+func (c *Changer) Drive(d int) Element {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d < 0 || d >= len(c.drives) {
+		return Element{}
+	}
+	return c.drives[d]
+}
+
+// Slot returns the state of storage element n
+func (c *Changer) Slot(n int) Element {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n < 1 || n > len(c.slots) {
+		return Element{}
+	}
+	return c.slots[n-1]
+}
+
+// Run executes an mtx command line (without "-f device") against
+// the Changer, returning mtx compatible stdout and stderr.  A
+// failing command returns a non-nil error as mtx would exit non-zero.
+func (c *Changer) Run(ctx context.Context, device string, args ...string) ([]byte, []byte, error) {
+	var stdout bytes.Buffer
+	err := c.ExecContext(ctx, &stdout, device, args...)
+	if err != nil {
+		return stdout.Bytes(), []byte(err.Error() + "\n"), errors.Wrap(err, "exit status 1")
+	}
+	return stdout.Bytes(), nil, nil
+}
+
+// Exec executes an mtx command line writing stdout to w.  The
+// returned error text is what mtx would print to stderr.
+func (c *Changer) Exec(w io.Writer, device string, args ...string) error {
+	return c.ExecContext(context.Background(), w, device, args...)
+}
+
+// ExecContext is like Exec, but a delayed or hanging command injected
+// by a Fault gives up with the context error when ctx is done
+func (c *Changer) ExecContext(ctx context.Context, w io.Writer, device string, args ...string) error {
+	f := c.trigger(args)
+	if f != nil {
+		if err := f.wait(ctx); err != nil {
+			return err
+		}
+		if !f.AfterMove && f.fails() {
+			return f.err(args)
+		}
+	}
+
+	c.mu.Lock()
+	err := c.exec(w, device, f, args)
+	c.mu.Unlock()
+	if err == nil && f != nil && f.AfterMove && f.fails() {
+		return f.err(args)
+	}
+	return err
+}
+
+func (c *Changer) exec(w io.Writer, device string, f *Fault, args []string) error {
+	if len(args) == 0 {
+		return errors.New("No command specified")
+	}
+	nums, err := atois(args[1:])
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "status":
+		c.status(w, device, f)
+		return nil
+	case "inventory":
+		return nil
+	case "loaderinfo":
+		c.loaderInfo(w)
+		return nil
+	case "load":
+		if len(nums) < 1 || len(nums) > 2 {
+			return errors.New("Usage: load <slotnum> [<drivenum>]")
+		}
+		drive := 0
+		if len(nums) == 2 {
+			drive = nums[1]
+		}
+		if err := c.load(nums[0], drive); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Loading media from Storage Element %d into drive %d...done\n", nums[0], drive)
+		return nil
+	case "unload":
+		if len(nums) > 2 {
+			return errors.New("Usage: unload [<slotnum>] [<drivenum>]")
+		}
+		slot, drive := 0, 0
+		if len(nums) > 0 {
+			slot = nums[0]
+		}
+		if len(nums) > 1 {
+			drive = nums[1]
+		}
+		slot, err := c.unload(slot, drive)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Unloading drive %d into Storage Element %d...done\n", drive, slot)
+		return nil
+	case "first", "next":
+		if len(nums) > 1 {
+			return errors.Errorf("Usage: %s [<drivenum>]", args[0])
+		}
+		drive := 0
+		if len(nums) == 1 {
+			drive = nums[0]
+		}
+		return c.loadNext(w, args[0] == "next", drive)
+	case "eject":
+		// Standalone loaders eject the tape from the drive, there is
+		// no change to the element state we track
+		return nil
+	case "transfer":
+		if len(nums) != 2 {
+			return errors.New("Usage: transfer <slotnum> <slotnum>")
+		}
+		return c.transfer(nums[0], nums[1])
+	case "exchange":
+		if len(nums) < 2 || len(nums) > 3 {
+			return errors.New("Usage: exchange <slotnum> <slotnum> [<slotnum>]")
+		}
+		dst2 := nums[0]
+		if len(nums) == 3 {
+			dst2 = nums[2]
+		}
+		return c.exchange(nums[0], nums[1], dst2)
+	}
+	return errors.Errorf("Invalid command: %s", args[0])
+}
+
+func atois(args []string) ([]int, error) {
+	nums := make([]int, 0, len(args))
+	for _, a := range args {
+		n, err := strconv.Atoi(a)
+		if err != nil {
+			return nil, errors.Errorf("Invalid element number: %s", a)
+		}
+		nums = append(nums, n)
+	}
+	return nums, nil
+}
+
+// status writes the mtx 1.3.12 "status" output
+func (c *Changer) status(w io.Writer, device string, f *Fault) {
+	fmt.Fprintf(w, "  Storage Changer %s:%d Drives, %d Slots ( %d Import/Export )\n",
+		device, len(c.drives), len(c.slots), c.ie)
+	for i, d := range c.drives {
+		fmt.Fprintf(w, "Data Transfer Element %d:", i)
+		switch {
+		case !d.Full:
+			io.WriteString(w, "Empty\n")
+			continue
+		case d.Src == 0:
+			io.WriteString(w, "Full (Unknown Storage Element Loaded)")
+		default:
+			fmt.Fprintf(w, "Full (Storage Element %d Loaded)", d.Src)
+		}
+		if d.Tag != "" {
+			fmt.Fprintf(w, ":VolumeTag = %s", d.Tag)
+		}
+		if d.AltTag != "" {
+			fmt.Fprintf(w, ":AlternateVolumeTag = %s", d.AltTag)
+		}
+		io.WriteString(w, "\n")
+	}
+	for i, s := range c.slots {
+		ie := ""
+		if i >= len(c.slots)-c.ie {
+			ie = " IMPORT/EXPORT"
+		}
+		fmt.Fprintf(w, "      Storage Element %d%s:", i+1, ie)
+		if !s.Full {
+			io.WriteString(w, "Empty\n")
+			continue
+		}
+		io.WriteString(w, "Full ")
+		if s.Tag != "" && !f.hides(i+1) {
+			fmt.Fprintf(w, ":VolumeTag=%s", s.Tag)
+		}
+		if s.AltTag != "" && !f.hides(i+1) {
+			fmt.Fprintf(w, ":AlternateVolumeTag=%s", s.AltTag)
+		}
+		io.WriteString(w, "\n")
+	}
+}
+
+// loaderInfo writes "mtx loaderinfo" style output including the
+// first element address of each element type
+func (c *Changer) loaderInfo(w io.Writer) {
+	fmt.Fprintf(w, "Product Type: Medium Changer\n")
+	fmt.Fprintf(w, "Vendor ID: 'MTXTEST '\n")
+	fmt.Fprintf(w, "Product ID: 'Simulated Changer'\n")
+	fmt.Fprintf(w, "Revision: '1.00'\n")
+	fmt.Fprintf(w, "Attached Changer API: No\n")
+	fmt.Fprintf(w, "EAAP: Yes\n")
+	fmt.Fprintf(w, "Number of Medium Transport Elements: 1\n")
+	fmt.Fprintf(w, "Number of Storage Elements: %d\n", len(c.slots)-c.ie)
+	fmt.Fprintf(w, "Number of Import/Export Element Elements: %d\n", c.ie)
+	fmt.Fprintf(w, "Number of Data Transfer Elements: %d\n", len(c.drives))
+	fmt.Fprintf(w, "First Medium Transport Element Address: %d\n", FirstTransportAddress)
+	fmt.Fprintf(w, "First Storage Element Address: %d\n", FirstSlotAddress)
+	fmt.Fprintf(w, "First Import/Export Element Address: %d\n", FirstImportExportAddress)
+	fmt.Fprintf(w, "First Data Transfer Element Address: %d\n", FirstDriveAddress)
+}
+
+func (c *Changer) checkDrive(d int) error {
+	if d < 0 || d >= len(c.drives) {
+		return errors.Errorf("Invalid Data Transfer Element Number %d", d)
+	}
+	return nil
+}
+
+func (c *Changer) checkSlot(n int) error {
+	if n < 1 || n > len(c.slots) {
+		return errors.Errorf("Invalid Storage Element Number %d", n)
+	}
+	return nil
+}
+
+func (c *Changer) load(slot, drive int) error {
+	if err := c.checkSlot(slot); err != nil {
+		return err
+	}
+	if err := c.checkDrive(drive); err != nil {
+		return err
+	}
+	if d := c.drives[drive]; d.Full {
+		return errors.Errorf("Drive %d Full (Storage Element %d loaded)", drive, d.Src)
+	}
+	s := c.slots[slot-1]
+	if !s.Full {
+		return errors.Errorf("Storage Element %d is Empty", slot)
+	}
+	c.drives[drive] = Element{Full: true, Tag: s.Tag, AltTag: s.AltTag, Src: slot}
+	c.slots[slot-1] = Element{}
+	return nil
+}
+
+// loadNext loads the first full storage slot into drive, or with next
+// set unloads the drive first and loads the slot after its source
+func (c *Changer) loadNext(w io.Writer, next bool, drive int) error {
+	if err := c.checkDrive(drive); err != nil {
+		return err
+	}
+	start := 1
+	if next && c.drives[drive].Full {
+		src := c.drives[drive].Src
+		slot, err := c.unload(0, drive)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Unloading drive %d into Storage Element %d...done\n", drive, slot)
+		start = src + 1
+	}
+	for n := start; n <= len(c.slots)-c.ie; n++ {
+		if c.slots[n-1].Full {
+			if err := c.load(n, drive); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "Loading media from Storage Element %d into drive %d...done\n", n, drive)
+			return nil
+		}
+	}
+	return errors.New("No More Media")
+}
+
+// unload moves the media in drive to slot, or to the storage element
+// it was loaded from if slot is 0, and returns the destination used
+func (c *Changer) unload(slot, drive int) (int, error) {
+	if err := c.checkDrive(drive); err != nil {
+		return 0, err
+	}
+	d := c.drives[drive]
+	if !d.Full {
+		return 0, errors.Errorf("Data Transfer Element %d is Empty", drive)
+	}
+	if slot == 0 {
+		slot = d.Src
+		if slot == 0 {
+			return 0, errors.Errorf("No source storage element for drive %d, specify a slot", drive)
+		}
+	}
+	if err := c.checkSlot(slot); err != nil {
+		return 0, err
+	}
+	if c.slots[slot-1].Full {
+		return 0, errors.Errorf("Storage Element %d is Already Full", slot)
+	}
+	c.slots[slot-1] = Element{Full: true, Tag: d.Tag, AltTag: d.AltTag}
+	c.drives[drive] = Element{}
+	return slot, nil
+}
+
+func (c *Changer) transfer(src, dst int) error {
+	if err := c.checkSlot(src); err != nil {
+		return err
+	}
+	if err := c.checkSlot(dst); err != nil {
+		return err
+	}
+	if !c.slots[src-1].Full {
+		return errors.Errorf("source Element Address %d is Empty", src)
+	}
+	if c.slots[dst-1].Full {
+		return errors.Errorf("destination Element Address %d is Already Full", dst)
+	}
+	c.slots[dst-1] = c.slots[src-1]
+	c.slots[src-1] = Element{}
+	return nil
+}
+
+// exchange moves the media in src to dst1 and the media that was in
+// dst1 to dst2, which may be src itself for a simple swap
+func (c *Changer) exchange(src, dst1, dst2 int) error {
+	for _, n := range []int{src, dst1, dst2} {
+		if err := c.checkSlot(n); err != nil {
+			return err
+		}
+	}
+	if !c.slots[src-1].Full {
+		return errors.Errorf("source Element Address %d is Empty", src)
+	}
+	if !c.slots[dst1-1].Full {
+		return errors.Errorf("destination Element Address %d is Empty", dst1)
+	}
+	if dst2 != src && c.slots[dst2-1].Full {
+		return errors.Errorf("second destination Element Address %d is Already Full", dst2)
+	}
+	a, b := c.slots[src-1], c.slots[dst1-1]
+	c.slots[src-1] = Element{}
+	c.slots[dst1-1] = a
+	c.slots[dst2-1] = b
+	return nil
+}
@@ -0,0 +1,202 @@
+package mtxtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/benmcclelland/mtx"
+	"github.com/pkg/errors"
+)
+
+const mockStatus = `  Storage Changer /dev/sga:2 Drives, 6 Slots ( 2 Import/Export )
+Data Transfer Element 0:Full (Storage Element 1 Loaded):VolumeTag = M00001L6
+Data Transfer Element 1:Empty
+      Storage Element 1:Empty
+      Storage Element 2:Empty
+      Storage Element 3:Full :VolumeTag=M00003L6
+      Storage Element 4:Full :VolumeTag=CLN004L6
+      Storage Element 5 IMPORT/EXPORT:Full :VolumeTag=M00002L6
+      Storage Element 6 IMPORT/EXPORT:Empty
+`
+
+func newMock() *Changer {
+	return New(Config{
+		Drives:       2,
+		Slots:        4,
+		ImportExport: 2,
+		Volumes: map[int]string{
+			1: "M00001L6",
+			3: "M00003L6",
+			4: "CLN004L6",
+			5: "M00002L6",
+		},
+		Loaded: map[int]int{0: 1},
+	})
+}
+
+func TestStatusOutput(t *testing.T) {
+	out, _, err := newMock().Run(context.Background(), "/dev/sga", "status")
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if string(out) != mockStatus {
+		t.Errorf("status: expected\n%s\ngot\n%s", mockStatus, out)
+	}
+}
+
+func TestLibraryRoundTrip(t *testing.T) {
+	c := newMock()
+	lib := mtx.NewLibraryExecutor("/dev/sga", c)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Load(m.Slots["3"].Vol, m.Drives["1"]); err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+	if err := lib.Unload(m.Drives["0"].Vol); err != nil {
+		t.Fatalf("Unload(): %v", err)
+	}
+	m, err = lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if m.Drives["1"].Vol == nil || m.Drives["1"].Vol.ID != "M00003L6" {
+		t.Errorf("expected M00003L6 in drive 1, got %+v", m.Drives["1"].Vol)
+	}
+	if m.Drives["0"].Vol != nil {
+		t.Errorf("expected drive 0 empty, got %+v", m.Drives["0"].Vol)
+	}
+	if m.Slots["1"].Vol == nil || m.Slots["1"].Vol.ID != "M00001L6" {
+		t.Errorf("expected M00001L6 in slot 1, got %+v", m.Slots["1"].Vol)
+	}
+	if m.Slots["3"].Vol != nil {
+		t.Errorf("expected slot 3 empty, got %+v", m.Slots["3"].Vol)
+	}
+}
+
+func TestPhysicalRules(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"load", "3", "0"}, "Drive 0 Full (Storage Element 1 loaded)"},
+		{[]string{"load", "2", "1"}, "Storage Element 2 is Empty"},
+		{[]string{"load", "3", "7"}, "Invalid Data Transfer Element Number 7"},
+		{[]string{"unload", "2", "1"}, "Data Transfer Element 1 is Empty"},
+		{[]string{"unload", "3", "0"}, "Storage Element 3 is Already Full"},
+		{[]string{"transfer", "2", "1"}, "source Element Address 2 is Empty"},
+		{[]string{"transfer", "3", "4"}, "destination Element Address 4 is Already Full"},
+		{[]string{"exchange", "3", "2"}, "destination Element Address 2 is Empty"},
+		{[]string{"exchange", "3", "4", "5"}, "second destination Element Address 5 is Already Full"},
+		{[]string{"bogus"}, "Invalid command: bogus"},
+	}
+	for _, tt := range tests {
+		_, stderr, err := newMock().Run(context.Background(), "/dev/sga", tt.args...)
+		if err == nil {
+			t.Errorf("%v: expected error, got success", tt.args)
+			continue
+		}
+		if string(stderr) != tt.err+"\n" {
+			t.Errorf("%v: expected stderr %q, got %q", tt.args, tt.err+"\n", stderr)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	c := newMock()
+	_, _, err := c.Run(context.Background(), "/dev/sga", "exchange", "3", "4")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if c.Slot(3).Tag != "CLN004L6" || c.Slot(4).Tag != "M00003L6" {
+		t.Errorf("exchange: expected slots 3 and 4 swapped, got %+v %+v", c.Slot(3), c.Slot(4))
+	}
+	_, _, err = c.Run(context.Background(), "/dev/sga", "exchange", "3", "4", "2")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if c.Slot(3).Full || c.Slot(4).Tag != "CLN004L6" || c.Slot(2).Tag != "M00003L6" {
+		t.Errorf("exchange: expected 3->4->2, got %+v %+v %+v", c.Slot(3), c.Slot(4), c.Slot(2))
+	}
+}
+
+func TestLibraryMove(t *testing.T) {
+	c := newMock()
+	lib := mtx.NewLibraryExecutor("/dev/sga", c)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	moves := [][2]mtx.Slot{
+		{m.Slots["3"], m.Mboxes["6"]},
+		{m.Mboxes["6"], m.Drives["1"]},
+		{m.Drives["0"], m.Slots["2"]},
+		{m.Drives["1"], m.Drives["0"]},
+		{m.Mboxes["5"], m.Slots["1"]},
+	}
+	for _, mv := range moves {
+		if err := lib.Move(mv[0], mv[1]); err != nil {
+			t.Fatalf("Move(%v %v, %v %v): %v", mv[0].Type, mv[0].ID, mv[1].Type, mv[1].ID, err)
+		}
+	}
+	cached, ok := lib.Cached()
+	if !ok {
+		t.Fatalf("Cached(): expected valid state")
+	}
+	m, err = lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	for s := range m.AllElements() {
+		var cs mtx.Slot
+		switch s.Type {
+		case mtx.DataTransferElement:
+			cs = cached.Drives[s.ID]
+		case mtx.StorageElement:
+			cs = cached.Slots[s.ID]
+		case mtx.ImportExport:
+			cs = cached.Mboxes[s.ID]
+		}
+		switch {
+		case (s.Vol == nil) != (cs.Vol == nil):
+			t.Errorf("%v %v: cached %+v, changer %+v", s.Type, s.ID, cs.Vol, s.Vol)
+		case s.Vol != nil && (s.Vol.ID != cs.Vol.ID || s.Vol.Drive != cs.Vol.Drive || s.Vol.Location != cs.Vol.Location):
+			t.Errorf("%v %v: cached %+v, changer %+v", s.Type, s.ID, cs.Vol, s.Vol)
+		}
+	}
+}
+
+func TestLibraryExchange(t *testing.T) {
+	c := newMock()
+	lib := mtx.NewLibraryExecutor("/dev/sga", c)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Exchange(m.Slots["3"], m.Mboxes["5"]); err != nil {
+		t.Fatalf("Exchange(): %v", err)
+	}
+	if c.Slot(3).Tag != "M00002L6" || c.Slot(5).Tag != "M00003L6" {
+		t.Errorf("Exchange(): expected slots 3 and 5 swapped, got %+v %+v", c.Slot(3), c.Slot(5))
+	}
+
+	c = New(Config{
+		Slots:   2,
+		Volumes: map[int]string{1: "M00001L6", 2: "M00002L6"},
+		Faults:  []Fault{{Command: "exchange", Sense: &Sense{Key: 5, ASC: 0x20}}},
+	})
+	lib = mtx.NewLibraryExecutor("/dev/sga", c)
+	if m, err = lib.Status(); err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	// mtx reports no capabilities, so the rejection is passed through
+	err = lib.Exchange(m.Slots["1"], m.Slots["2"])
+	if err == nil || errors.Is(err, mtx.ErrStateUnknown) || !strings.Contains(err.Error(), "Additional Sense Code = 20") {
+		t.Errorf("Exchange(): expected the mtx Illegal Request, got %v", err)
+	}
+	if _, ok := lib.Cached(); !ok {
+		t.Errorf("Exchange(): expected cache kept after a rejected exchange")
+	}
+}
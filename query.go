@@ -0,0 +1,200 @@
+package mtx
+
+import (
+	"iter"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// VolumeQuery selects volumes from a MediaInfo.  Each method returns a
+// new query, so a partial query can be reused and extended.  A query
+// with no filters matches every volume in every element, including
+// unlabeled volumes, which never match the barcode filters.
+// Results are in the order of AllElements unless InElements is used.
+type VolumeQuery struct {
+	mi *MediaInfo
+	// types are the element types to search in order, all if ntypes is 0
+	types  [3]SlotType
+	ntypes int
+	// barcode is matched using the barcode index if hasBarcode
+	barcode    string
+	hasBarcode bool
+	filters    []func(Slot) bool
+	limit      int
+}
+
+// allTypes is the search order when InElements is not used
+var allTypes = [3]SlotType{DataTransferElement, StorageElement, ImportExport}
+
+// Volumes returns a query over all of the volumes in mi
+func (mi *MediaInfo) Volumes() VolumeQuery {
+	return VolumeQuery{mi: mi}
+}
+
+// where returns a copy of q with volume filter f added
+func (q VolumeQuery) where(f func(*Volume) bool) VolumeQuery {
+	return q.whereSlot(func(s Slot) bool { return f(s.Vol) })
+}
+
+// whereSlot returns a copy of q with element filter f added
+func (q VolumeQuery) whereSlot(f func(Slot) bool) VolumeQuery {
+	q.filters = append(slices.Clip(q.filters), f)
+	return q
+}
+
+// InElements limits the query to the given element types, which are
+// searched in the order given
+func (q VolumeQuery) InElements(types ...SlotType) VolumeQuery {
+	q.ntypes = 0
+	for _, t := range types {
+		if slices.Contains(allTypes[:], t) && !slices.Contains(q.types[:q.ntypes], t) {
+			q.types[q.ntypes] = t
+			q.ntypes++
+		}
+	}
+	if q.ntypes == 0 {
+		// no valid types, match nothing
+		q.types[0] = Unknown
+		q.ntypes = 1
+	}
+	return q
+}
+
+// WithBarcode matches volumes with the given primary or alternate
+// barcode
+func (q VolumeQuery) WithBarcode(barcode string) VolumeQuery {
+	if q.hasBarcode && q.barcode != barcode {
+		return q.where(func(v *Volume) bool {
+			return barcode != "" && (v.ID == barcode || v.AltID == barcode)
+		})
+	}
+	q.barcode, q.hasBarcode = barcode, true
+	return q
+}
+
+// WithPrefix matches volumes with a primary or alternate barcode
+// starting with prefix
+func (q VolumeQuery) WithPrefix(prefix string) VolumeQuery {
+	return q.where(func(v *Volume) bool {
+		return v.matchTag(func(id string) bool { return strings.HasPrefix(id, prefix) })
+	})
+}
+
+// Matching matches volumes with a primary or alternate barcode matched
+// by re
+func (q VolumeQuery) Matching(re *regexp.Regexp) VolumeQuery {
+	return q.where(func(v *Volume) bool { return v.matchTag(re.MatchString) })
+}
+
+// Cleaning matches only cleaning media if cln is true, or only
+// non-cleaning media if false.  Cleaning media are recognized the same
+// way as FindCleaningMedia.
+func (q VolumeQuery) Cleaning(cln bool) VolumeQuery {
+	return q.where(func(v *Volume) bool { return clnRxp.MatchString(v.ID) == cln })
+}
+
+// Limit stops the query after n results.  Limit(0) removes the limit.
+func (q VolumeQuery) Limit(n int) VolumeQuery {
+	q.limit = n
+	return q
+}
+
+// match reports if the volume in s passes every filter
+func (q *VolumeQuery) match(s Slot) bool {
+	if s.Vol == nil {
+		return false
+	}
+	if q.hasBarcode && (q.barcode == "" || s.Vol.ID != q.barcode && s.Vol.AltID != q.barcode) {
+		return false
+	}
+	for _, f := range q.filters {
+		if !f(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// run calls yield for each matching slot until it returns false
+func (q VolumeQuery) run(yield func(Slot) bool) {
+	types := allTypes[:]
+	if q.ntypes > 0 {
+		types = q.types[:q.ntypes]
+	}
+	n := 0
+	emit := func(s Slot) bool {
+		if q.limit > 0 && n >= q.limit {
+			return false
+		}
+		if !q.match(s) {
+			return true
+		}
+		n++
+		return yield(s)
+	}
+	if q.hasBarcode && q.mi.index != nil {
+		ref, ok := q.mi.index[q.barcode]
+		switch {
+		case !ok:
+			return
+		case ref.typ != Unknown:
+			if slices.Contains(types, ref.typ) {
+				emit(q.mi.elements(ref.typ)[ref.id])
+			}
+			return
+		}
+	}
+	for _, t := range types {
+		for _, s := range sortedSlots(q.mi.elements(t)) {
+			if !emit(s) {
+				return
+			}
+		}
+	}
+}
+
+// Seq iterates over the elements holding the matching volumes
+func (q VolumeQuery) Seq() iter.Seq[Slot] {
+	return q.run
+}
+
+// Slots returns the elements holding the matching volumes
+func (q VolumeQuery) Slots() []Slot {
+	var result []Slot
+	q.run(func(s Slot) bool {
+		result = append(result, s)
+		return true
+	})
+	return result
+}
+
+// All returns the matching volumes
+func (q VolumeQuery) All() []*Volume {
+	var result []*Volume
+	q.run(func(s Slot) bool {
+		result = append(result, s.Vol)
+		return true
+	})
+	return result
+}
+
+// First returns the first matching volume
+func (q VolumeQuery) First() (*Volume, bool) {
+	var v *Volume
+	q.run(func(s Slot) bool {
+		v = s.Vol
+		return false
+	})
+	return v, v != nil
+}
+
+// Count returns the number of matching volumes
+func (q VolumeQuery) Count() int {
+	n := 0
+	q.run(func(Slot) bool {
+		n++
+		return true
+	})
+	return n
+}
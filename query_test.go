@@ -0,0 +1,96 @@
+package mtx
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func barcodes(vols []*Volume) string {
+	var s []string
+	for _, v := range vols {
+		s = append(s, v.ID)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestVolumeQuery(t *testing.T) {
+	m := parseOrderStatus(t)
+	base := m.Volumes().WithPrefix("A")
+	for _, tc := range []struct {
+		name string
+		q    VolumeQuery
+		want string
+	}{
+		{"all", m.Volumes(), "A00010,A00001,CLN003,A00004,A00006,A00007,CLN008,A00009,A00011"},
+		{"not in a drive", m.Volumes().InElements(StorageElement, ImportExport).Cleaning(false),
+			"A00001,A00004,A00006,A00007,A00009,A00011"},
+		{"cleaning", m.Volumes().Cleaning(true), "CLN003,CLN008"},
+		{"element order", m.Volumes().InElements(ImportExport, DataTransferElement, ImportExport),
+			"A00011,A00010"},
+		{"no valid elements", m.Volumes().InElements(Unknown), ""},
+		{"prefix", base, "A00010,A00001,A00004,A00006,A00007,A00009,A00011"},
+		{"prefix and pattern", base.Matching(regexp.MustCompile(`[02468]$`)), "A00010,A00004,A00006"},
+		{"branch 1", base.WithPrefix("A0001"), "A00010,A00011"},
+		{"branch 2", base.WithPrefix("A0000").Limit(2), "A00001,A00004"},
+		{"limit", m.Volumes().InElements(StorageElement).Limit(3), "A00001,CLN003,A00004"},
+		{"no limit", m.Volumes().Limit(1).Limit(0).Cleaning(true), "CLN003,CLN008"},
+		{"barcode", m.Volumes().WithBarcode("A00007"), "A00007"},
+		{"barcode wrong element", m.Volumes().WithBarcode("A00010").InElements(StorageElement), ""},
+		{"barcode filtered", m.Volumes().WithBarcode("CLN003").Cleaning(false), ""},
+		{"two barcodes", m.Volumes().WithBarcode("A00007").WithBarcode("A00009"), ""},
+		{"unknown barcode", m.Volumes().WithBarcode("NOSUCH"), ""},
+		{"empty barcode", m.Volumes().WithBarcode(""), ""},
+		{"no match", m.Volumes().WithPrefix("Z"), ""},
+	} {
+		if got := barcodes(tc.q.All()); got != tc.want {
+			t.Errorf("%v: All() expected %q, got %q", tc.name, tc.want, got)
+		}
+		want := 0
+		if tc.want != "" {
+			want = strings.Count(tc.want, ",") + 1
+		}
+		if n := tc.q.Count(); n != want {
+			t.Errorf("%v: Count() expected %v, got %v", tc.name, want, n)
+		}
+		v, ok := tc.q.First()
+		if ok != (want > 0) || ok && !strings.HasPrefix(tc.want, v.ID) {
+			t.Errorf("%v: First() expected first of %q, got %v %v", tc.name, tc.want, v, ok)
+		}
+	}
+
+	slots := m.Volumes().InElements(DataTransferElement, StorageElement).WithPrefix("A0001").Slots()
+	if len(slots) != 1 || slots[0].Type != DataTransferElement || slots[0].ID != "1" {
+		t.Errorf("Slots(): expected drive 1, got %v", slots)
+	}
+	if all := m.Volumes().All(); all == nil || m.Volumes().WithPrefix("Z").All() != nil {
+		t.Errorf("All(): expected nil only for no matches")
+	}
+}
+
+func TestVolumeQueryUnlabeled(t *testing.T) {
+	m, err := parseStatus(strings.NewReader(unlabeledStatus))
+	if err != nil {
+		t.Fatalf("parseStatus(): %v", err)
+	}
+	all := m.Volumes().Count()
+	labeled := m.Volumes().Matching(regexp.MustCompile(``)).Count()
+	if unlabeled := len(FindUnlabeled(&m)); all != labeled+unlabeled || unlabeled == 0 {
+		t.Errorf("Count(): expected %v labeled and %v unlabeled volumes, got %v", labeled, unlabeled, all)
+	}
+}
+
+func TestVolumeQueryUnindexed(t *testing.T) {
+	m := MediaInfo{
+		Drives: DriveInfo{"0": {Type: DataTransferElement, ID: "0", Address: -1,
+			Vol: &Volume{ID: "ABC002", Drive: "0"}}},
+		Slots: SlotInfo{"1": {Type: StorageElement, ID: "1", Address: -1,
+			Vol: &Volume{ID: "ABC001", Home: "1"}}},
+	}
+	if got := barcodes(m.Volumes().WithBarcode("ABC001").All()); got != "ABC001" {
+		t.Errorf("WithBarcode(ABC001): got %q", got)
+	}
+	if got := barcodes(m.Volumes().InElements(ImportExport).All()); got != "" {
+		t.Errorf("InElements(ImportExport): expected nothing from nil map, got %q", got)
+	}
+}
@@ -0,0 +1,524 @@
+package sg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benmcclelland/mtx/scsi/smc"
+	"github.com/pkg/errors"
+)
+
+// DefaultTimeout is the command timeout used when Executor.Timeout is
+// not set.  Moves and inventories can take minutes on large libraries.
+const DefaultTimeout = 10 * time.Minute
+
+// Executor runs mtx command lines by issuing SMC commands to the
+// device.  It satisfies the mtx.Executor interface.
+type Executor struct {
+	// Open returns the Transport for a device path, OpenSG if nil
+	Open func(device string) (Transport, error)
+	// Timeout is the timeout for each SCSI command, DefaultTimeout if 0
+	Timeout time.Duration
+}
+
+// Run executes an mtx command line (without "-f device").  Supported
+// commands are status, loaderinfo, inventory, load, unload, transfer
+// and exchange.  Unlike mtx, exchange also takes drives, written as D
+// followed by the drive number, such as "exchange D0 3", and is only
+// issued if the device capabilities page allows it.
+// SCSI command failures return an *smc.SenseError.  A SCSI command
+// cannot be interrupted, so if ctx is done while one is in progress,
+// Run returns the ctx error right away and leaves the command to
+// finish on its own; a move may still complete.
+func (e *Executor) Run(ctx context.Context, device string, args ...string) ([]byte, []byte, error) {
+	c, err := e.open(ctx, device)
+	if err != nil {
+		return nil, []byte(err.Error() + "\n"), err
+	}
+	defer c.close()
+
+	var stdout bytes.Buffer
+	if err := c.run(&stdout, device, args); err != nil {
+		return stdout.Bytes(), []byte(err.Error() + "\n"), err
+	}
+	return stdout.Bytes(), nil, nil
+}
+
+// Drives returns the data transfer elements of device in mtx drive
+// number order, including the device identifiers, such as the drive
+// serial number, that mtx status does not print
+func (e *Executor) Drives(ctx context.Context, device string) ([]smc.Element, error) {
+	c, err := e.open(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	if err := c.readStatus(); err != nil {
+		return nil, err
+	}
+	return c.drives, nil
+}
+
+// Elements returns the status of every element of device: the medium
+// transport elements, the drives in mtx drive number order, then the
+// storage and import/export elements in mtx element number order.
+// Unlike the address assignment page, it has the address of each
+// element, which need not be consecutive.
+func (e *Executor) Elements(ctx context.Context, device string) ([]smc.Element, error) {
+	c, err := e.open(ctx, device)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	if err := c.readStatus(); err != nil {
+		return nil, err
+	}
+	mt, err := c.readElements(smc.MediumTransport, c.aa.FirstTransport, c.aa.NumTransport)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(mt, c.drives...), c.slots...), nil
+}
+
+// Capabilities returns the device capabilities mode page of device,
+// which tells which element types EXCHANGE MEDIUM supports
+func (e *Executor) Capabilities(ctx context.Context, device string) (smc.Capabilities, error) {
+	c, err := e.open(ctx, device)
+	if err != nil {
+		return smc.Capabilities{}, err
+	}
+	defer c.close()
+
+	return c.readCapabilities()
+}
+
+// UnsupportedError is returned for an exchange between element types
+// that the device capabilities page does not allow.  The exchange is
+// not issued.
+type UnsupportedError struct {
+	Src, Dst smc.ElementType
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("EXCHANGE MEDIUM from %v to %v element not supported", e.Src, e.Dst)
+}
+
+// NotStarted reports that no command was issued
+func (e *UnsupportedError) NotStarted() bool {
+	return true
+}
+
+// OpenError is returned when the device could not be opened, so no
+// SCSI command was issued
+type OpenError struct {
+	Device string
+	Err    error
+}
+
+func (e *OpenError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error from Open
+func (e *OpenError) Unwrap() error {
+	return e.Err
+}
+
+// NotStarted reports that no command was issued, which tells an
+// mtx.Library that the changer state is unchanged
+func (e *OpenError) NotStarted() bool {
+	return true
+}
+
+// open opens device for a single command line
+func (e *Executor) open(ctx context.Context, device string) (*changer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	open := e.Open
+	if open == nil {
+		open = OpenSG
+	}
+	t, err := open(device)
+	if err != nil {
+		return nil, &OpenError{Device: device, Err: err}
+	}
+	c := &changer{t: t, ctx: ctx, timeout: e.Timeout}
+	if c.timeout == 0 {
+		c.timeout = DefaultTimeout
+	}
+	return c, nil
+}
+
+// changer holds the state for a single command line
+type changer struct {
+	t       Transport
+	ctx     context.Context
+	timeout time.Duration
+	aa      smc.AddressAssignment
+	// busy is set when a command was abandoned and is still running
+	busy chan error
+
+	drives []smc.Element
+	// slots are the storage elements followed by the import/export
+	// elements, in mtx storage element number order
+	slots []smc.Element
+	ie    int
+}
+
+// do issues cdb, giving up on it if ctx is done first.  The Transport
+// cannot be interrupted, so an abandoned command keeps running and the
+// device is only closed once it returns.
+func (c *changer) do(cdb []byte, dir Direction, data []byte) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	timeout := c.timeout
+	if d, ok := c.ctx.Deadline(); ok {
+		left := time.Until(d)
+		if left <= 0 {
+			return context.DeadlineExceeded
+		}
+		if left < timeout {
+			timeout = left
+		}
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.t.Do(cdb, dir, data, timeout) }()
+	select {
+	case err := <-done:
+		return err
+	case <-c.ctx.Done():
+		c.busy = done
+		return errors.Wrapf(c.ctx.Err(), "SCSI command %#02x abandoned in progress", cdb[0])
+	}
+}
+
+// close closes the Transport, after any abandoned command returns
+func (c *changer) close() {
+	if c.busy == nil {
+		c.t.Close()
+		return
+	}
+	go func() {
+		<-c.busy
+		c.t.Close()
+	}()
+}
+
+func (c *changer) run(w io.Writer, device string, args []string) error {
+	if len(args) == 0 {
+		return errors.New("No command specified")
+	}
+	// exchange parses its own arguments, which may be drives
+	nums := make([]int, 0, len(args)-1)
+	for _, a := range args[1:] {
+		if args[0] == "exchange" {
+			break
+		}
+		n, err := strconv.Atoi(a)
+		if err != nil {
+			return errors.Errorf("Invalid element number: %s", a)
+		}
+		nums = append(nums, n)
+	}
+
+	if args[0] == "inventory" {
+		return errors.Wrap(c.do(smc.InitializeElementStatus(), None, nil),
+			"INITIALIZE ELEMENT STATUS Failed")
+	}
+	if args[0] == "loaderinfo" {
+		if err := c.readAddressAssignment(); err != nil {
+			return err
+		}
+		c.loaderInfo(w)
+		return nil
+	}
+	if err := c.readStatus(); err != nil {
+		return err
+	}
+	switch args[0] {
+	case "status":
+		c.status(w, device)
+		return nil
+	case "load":
+		if len(nums) < 1 || len(nums) > 2 {
+			return errors.New("Usage: load <slotnum> [<drivenum>]")
+		}
+		drive := 0
+		if len(nums) == 2 {
+			drive = nums[1]
+		}
+		src, err := c.slot(nums[0])
+		if err != nil {
+			return err
+		}
+		dst, err := c.drive(drive)
+		if err != nil {
+			return err
+		}
+		if err := c.move(src, dst); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Loading media from Storage Element %d into drive %d...done\n", nums[0], drive)
+		return nil
+	case "unload":
+		if len(nums) > 2 {
+			return errors.New("Usage: unload [<slotnum>] [<drivenum>]")
+		}
+		drive := 0
+		if len(nums) > 1 {
+			drive = nums[1]
+		}
+		src, err := c.drive(drive)
+		if err != nil {
+			return err
+		}
+		slot := 0
+		if len(nums) > 0 {
+			slot = nums[0]
+		} else if src.SourceValid {
+			slot = c.number(src.Source)
+		}
+		dst, err := c.slot(slot)
+		if err != nil {
+			return err
+		}
+		if err := c.move(src, dst); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Unloading drive %d into Storage Element %d...done\n", drive, slot)
+		return nil
+	case "transfer":
+		if len(nums) != 2 {
+			return errors.New("Usage: transfer <slotnum> <slotnum>")
+		}
+		src, err := c.slot(nums[0])
+		if err != nil {
+			return err
+		}
+		dst, err := c.slot(nums[1])
+		if err != nil {
+			return err
+		}
+		return c.move(src, dst)
+	case "exchange":
+		if len(args) < 3 || len(args) > 4 {
+			return errors.New("Usage: exchange <slotnum> <slotnum> [<slotnum>]")
+		}
+		src, err := c.element(args[1])
+		if err != nil {
+			return err
+		}
+		dst1, err := c.element(args[2])
+		if err != nil {
+			return err
+		}
+		dst2 := src
+		if len(args) == 4 {
+			if dst2, err = c.element(args[3]); err != nil {
+				return err
+			}
+		}
+		return c.exchange(src, dst1, dst2)
+	}
+	return errors.Errorf("Invalid command: %s", args[0])
+}
+
+func (c *changer) move(src, dst smc.Element) error {
+	err := c.do(smc.MoveMedium(c.aa.FirstTransport, src.Address, dst.Address), None, nil)
+	return errors.Wrapf(err, "MOVE MEDIUM from Element Address %d to %d Failed",
+		src.Address, dst.Address)
+}
+
+// exchange issues EXCHANGE MEDIUM if the device capabilities page
+// allows it for the element types involved
+func (c *changer) exchange(src, dst1, dst2 smc.Element) error {
+	caps, err := c.readCapabilities()
+	if err != nil {
+		return err
+	}
+	for _, p := range [][2]smc.Element{{src, dst1}, {dst1, dst2}} {
+		if !caps.Exchange[p[0].Type][p[1].Type] {
+			return &UnsupportedError{Src: p[0].Type, Dst: p[1].Type}
+		}
+	}
+	err = c.do(smc.ExchangeMedium(c.aa.FirstTransport, src.Address, dst1.Address, dst2.Address), None, nil)
+	return errors.Wrapf(err, "EXCHANGE MEDIUM from Element Address %d to %d Failed",
+		src.Address, dst1.Address)
+}
+
+// element returns the drive for D followed by a drive number,
+// otherwise the storage or import/export element with that number
+func (c *changer) element(arg string) (smc.Element, error) {
+	d := strings.TrimPrefix(arg, "D")
+	n, err := strconv.Atoi(d)
+	if err != nil {
+		return smc.Element{}, errors.Errorf("Invalid element number: %s", arg)
+	}
+	if d != arg {
+		return c.drive(n)
+	}
+	return c.slot(n)
+}
+
+func (c *changer) drive(n int) (smc.Element, error) {
+	if n < 0 || n >= len(c.drives) {
+		return smc.Element{}, errors.Errorf("Invalid Data Transfer Element Number %d", n)
+	}
+	return c.drives[n], nil
+}
+
+func (c *changer) slot(n int) (smc.Element, error) {
+	if n < 1 || n > len(c.slots) {
+		return smc.Element{}, errors.Errorf("Invalid Storage Element Number %d", n)
+	}
+	return c.slots[n-1], nil
+}
+
+// number returns the mtx storage element number for an element
+// address, or 0 if it is not a storage or import/export element
+func (c *changer) number(addr uint16) int {
+	for i, s := range c.slots {
+		if s.Address == addr {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// readAddressAssignment reads the element address assignment page
+func (c *changer) readAddressAssignment() error {
+	ms := make([]byte, 255)
+	err := c.do(smc.ModeSense6(smc.PageElementAddressAssignment, byte(len(ms))), FromDevice, ms)
+	if err != nil {
+		return errors.Wrap(err, "MODE SENSE Failed")
+	}
+	c.aa, err = smc.DecodeAddressAssignment(ms)
+	return err
+}
+
+// readCapabilities reads the device capabilities page
+func (c *changer) readCapabilities() (smc.Capabilities, error) {
+	ms := make([]byte, 255)
+	err := c.do(smc.ModeSense6(smc.PageDeviceCapabilities, byte(len(ms))), FromDevice, ms)
+	if err != nil {
+		return smc.Capabilities{}, errors.Wrap(err, "MODE SENSE Failed")
+	}
+	return smc.DecodeCapabilities(ms)
+}
+
+// readStatus reads the address assignment and the status of every
+// element
+func (c *changer) readStatus() error {
+	if err := c.readAddressAssignment(); err != nil {
+		return err
+	}
+
+	var err error
+	if c.drives, err = c.readElements(smc.DataTransfer, c.aa.FirstDataTransfer, c.aa.NumDataTransfer); err != nil {
+		return err
+	}
+	st, err := c.readElements(smc.Storage, c.aa.FirstStorage, c.aa.NumStorage)
+	if err != nil {
+		return err
+	}
+	ie, err := c.readElements(smc.ImportExport, c.aa.FirstImportExport, c.aa.NumImportExport)
+	if err != nil {
+		return err
+	}
+	c.slots = append(st, ie...)
+	c.ie = len(ie)
+	return nil
+}
+
+// readElements reads the status of count elements of type t from
+// address start, with device identifiers for drives if supported
+func (c *changer) readElements(t smc.ElementType, start, count uint16) ([]smc.Element, error) {
+	if count == 0 {
+		return nil, nil
+	}
+	// room for descriptors with both volume tags and a device identifier
+	alloc := 8 + 8 + int(count)*(12+36+36+36)
+	if alloc > 0xffffff {
+		alloc = 0xffffff
+	}
+	buf := make([]byte, alloc)
+	opts := smc.StatusOptions{VolTag: true, DVCID: t == smc.DataTransfer}
+	err := c.do(smc.ReadElementStatus(t, start, count, uint32(alloc), opts), FromDevice, buf)
+	if s, ok := errors.Cause(err).(*smc.SenseError); ok && s.Key == smc.IllegalRequest && opts.DVCID {
+		// not every changer reports drive identifiers
+		opts.DVCID = false
+		err = c.do(smc.ReadElementStatus(t, start, count, uint32(alloc), opts), FromDevice, buf)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "READ ELEMENT STATUS Failed")
+	}
+	return smc.DecodeElementStatus(buf)
+}
+
+// status writes the element status in the format of mtx 1.3.12
+func (c *changer) status(w io.Writer, device string) {
+	fmt.Fprintf(w, "  Storage Changer %s:%d Drives, %d Slots ( %d Import/Export )\n",
+		device, len(c.drives), len(c.slots), c.ie)
+	for i, d := range c.drives {
+		fmt.Fprintf(w, "Data Transfer Element %d:", i)
+		if !d.Full {
+			io.WriteString(w, "Empty\n")
+			continue
+		}
+		if n := c.number(d.Source); d.SourceValid && n != 0 {
+			fmt.Fprintf(w, "Full (Storage Element %d Loaded)", n)
+		} else {
+			io.WriteString(w, "Full (Unknown Storage Element Loaded)")
+		}
+		if d.VolumeTag != "" {
+			fmt.Fprintf(w, ":VolumeTag = %s", d.VolumeTag)
+		}
+		if d.AltVolumeTag != "" {
+			fmt.Fprintf(w, ":AlternateVolumeTag = %s", d.AltVolumeTag)
+		}
+		io.WriteString(w, "\n")
+	}
+	for i, s := range c.slots {
+		ie := ""
+		if i >= len(c.slots)-c.ie {
+			ie = " IMPORT/EXPORT"
+		}
+		fmt.Fprintf(w, "      Storage Element %d%s:", i+1, ie)
+		if !s.Full {
+			io.WriteString(w, "Empty\n")
+			continue
+		}
+		io.WriteString(w, "Full ")
+		if s.VolumeTag != "" {
+			fmt.Fprintf(w, ":VolumeTag=%s", s.VolumeTag)
+		}
+		if s.AltVolumeTag != "" {
+			fmt.Fprintf(w, ":AlternateVolumeTag=%s", s.AltVolumeTag)
+		}
+		io.WriteString(w, "\n")
+	}
+}
+
+// loaderInfo writes the element counts like "mtx loaderinfo" followed
+// by the first element address of each type
+func (c *changer) loaderInfo(w io.Writer) {
+	fmt.Fprintf(w, "Product Type: Medium Changer\n")
+	fmt.Fprintf(w, "EAAP: Yes\n")
+	fmt.Fprintf(w, "Number of Medium Transport Elements: %d\n", c.aa.NumTransport)
+	fmt.Fprintf(w, "Number of Storage Elements: %d\n", c.aa.NumStorage)
+	fmt.Fprintf(w, "Number of Import/Export Element Elements: %d\n", c.aa.NumImportExport)
+	fmt.Fprintf(w, "Number of Data Transfer Elements: %d\n", c.aa.NumDataTransfer)
+	fmt.Fprintf(w, "First Medium Transport Element Address: %d\n", c.aa.FirstTransport)
+	fmt.Fprintf(w, "First Storage Element Address: %d\n", c.aa.FirstStorage)
+	fmt.Fprintf(w, "First Import/Export Element Address: %d\n", c.aa.FirstImportExport)
+	fmt.Fprintf(w, "First Data Transfer Element Address: %d\n", c.aa.FirstDataTransfer)
+}
@@ -0,0 +1,528 @@
+package sg
+
+import (
+	"context"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/benmcclelland/mtx"
+	"github.com/benmcclelland/mtx/scsi/smc"
+	"github.com/pkg/errors"
+)
+
+// fakeElement is an element held by fakeTarget
+type fakeElement struct {
+	t    smc.ElementType
+	addr uint16
+	tag  string
+	full bool
+	src  uint16
+	// id is the device identifier of a drive
+	id string
+}
+
+// fakeTarget is a byte level SMC target answering CDBs like a
+// small library with element addresses laid out as vendors do
+type fakeTarget struct {
+	elems []*fakeElement
+	cdbs  [][]byte
+	// noDVCID rejects requests for device identifiers
+	noDVCID bool
+	// moveErr fails MOVE MEDIUM
+	moveErr error
+	// noExchange reports no EXCHANGE MEDIUM support
+	noExchange bool
+}
+
+func newFakeTarget() *fakeTarget {
+	return &fakeTarget{elems: []*fakeElement{
+		{t: smc.MediumTransport, addr: 0},
+		{t: smc.DataTransfer, addr: 256, full: true, tag: "M00001L6", src: 4096, id: "IBM     ULT3580-TD6     1013000123"},
+		{t: smc.DataTransfer, addr: 257, id: "IBM     ULT3580-TD6     1013000456"},
+		{t: smc.Storage, addr: 4096},
+		{t: smc.Storage, addr: 4097},
+		{t: smc.Storage, addr: 4098, full: true, tag: "M00003L6"},
+		{t: smc.Storage, addr: 4099, full: true, tag: "CLN004L6"},
+		{t: smc.ImportExport, addr: 16, full: true, tag: "M00002L6"},
+		{t: smc.ImportExport, addr: 17},
+	}}
+}
+
+func (f *fakeTarget) find(addr uint16) *fakeElement {
+	for _, e := range f.elems {
+		if e.addr == addr {
+			return e
+		}
+	}
+	return nil
+}
+
+func (f *fakeTarget) count(t smc.ElementType) (first, n uint16) {
+	for _, e := range f.elems {
+		if e.t == t {
+			if n == 0 {
+				first = e.addr
+			}
+			n++
+		}
+	}
+	return first, n
+}
+
+func (f *fakeTarget) Do(cdb []byte, dir Direction, data []byte, timeout time.Duration) error {
+	f.cdbs = append(f.cdbs, append([]byte(nil), cdb...))
+	switch cdb[0] {
+	case smc.OpInitializeElementStatus:
+		return nil
+	case smc.OpModeSense6:
+		if cdb[2]&0x3f == smc.PageDeviceCapabilities {
+			// drives, storage and import/export store, move and,
+			// unless noExchange, exchange between each other
+			p := make([]byte, 4+20)
+			p[0], p[4], p[5], p[6] = byte(len(p)-1), smc.PageDeviceCapabilities, 0x12, 0x0e
+			for t := smc.Storage; t <= smc.DataTransfer; t++ {
+				p[4+4+int(t)-1] = 0x0e
+				if !f.noExchange {
+					p[4+12+int(t)-1] = 0x0e
+				}
+			}
+			copy(data, p)
+			return nil
+		}
+		p := []byte{3, 0, 0, 0, smc.PageElementAddressAssignment, 0x12}
+		for _, t := range []smc.ElementType{smc.MediumTransport, smc.Storage, smc.ImportExport, smc.DataTransfer} {
+			first, n := f.count(t)
+			p = append(p, byte(first>>8), byte(first), byte(n>>8), byte(n))
+		}
+		p = append(p, 0, 0)
+		p[0] = byte(len(p) - 1)
+		copy(data, p)
+		return nil
+	case smc.OpReadElementStatus:
+		t := smc.ElementType(cdb[1] & 0x0f)
+		dvcid := cdb[6]&0x01 != 0
+		if dvcid && f.noDVCID {
+			return &smc.SenseError{Key: 5, ASC: 0x24, ASCQ: 0x00}
+		}
+		dlen := 12 + 36
+		if dvcid {
+			dlen += 4 + 34
+		}
+		var page []byte
+		for _, e := range f.elems {
+			if e.t != t {
+				continue
+			}
+			d := make([]byte, dlen)
+			binary.BigEndian.PutUint16(d, e.addr)
+			if e.full {
+				d[2] = 0x01
+			}
+			if e.src != 0 {
+				d[9] = 0x80
+				binary.BigEndian.PutUint16(d[10:], e.src)
+			}
+			copy(d[12:44], e.tag+"                                ")
+			if dvcid {
+				d[48], d[49], d[51] = 2, 1, byte(len(e.id))
+				copy(d[52:], e.id)
+			}
+			page = append(page, d...)
+		}
+		hdr := []byte{byte(t), 0x80, 0, byte(dlen), 0, byte(len(page) >> 16), byte(len(page) >> 8), byte(len(page))}
+		page = append(hdr, page...)
+		out := []byte{0, 0, 0, 0, 0, byte(len(page) >> 16), byte(len(page) >> 8), byte(len(page))}
+		copy(data, append(out, page...))
+		return nil
+	case smc.OpMoveMedium:
+		if f.moveErr != nil {
+			return f.moveErr
+		}
+		src := f.find(binary.BigEndian.Uint16(cdb[4:]))
+		dst := f.find(binary.BigEndian.Uint16(cdb[6:]))
+		switch {
+		case src == nil || dst == nil:
+			return &smc.SenseError{Key: 5, ASC: 0x21, ASCQ: 0x01}
+		case !src.full:
+			return &smc.SenseError{Key: 5, ASC: 0x3b, ASCQ: 0x0e}
+		case dst.full:
+			return &smc.SenseError{Key: 5, ASC: 0x3b, ASCQ: 0x0d}
+		}
+		dst.full, dst.tag, dst.src = true, src.tag, src.addr
+		src.full, src.tag, src.src = false, "", 0
+		return nil
+	case smc.OpExchangeMedium:
+		src := f.find(binary.BigEndian.Uint16(cdb[4:]))
+		dst1 := f.find(binary.BigEndian.Uint16(cdb[6:]))
+		dst2 := f.find(binary.BigEndian.Uint16(cdb[8:]))
+		switch {
+		case src == nil || dst1 == nil || dst2 == nil:
+			return &smc.SenseError{Key: 5, ASC: 0x21, ASCQ: 0x01}
+		case !src.full || !dst1.full:
+			return &smc.SenseError{Key: 5, ASC: 0x3b, ASCQ: 0x0e}
+		case dst2 != src && dst2.full:
+			return &smc.SenseError{Key: 5, ASC: 0x3b, ASCQ: 0x0d}
+		}
+		tag1, tag2 := src.tag, dst1.tag
+		src.full, src.tag, src.src = false, "", 0
+		dst1.tag, dst1.src = tag1, src.addr
+		dst2.full, dst2.tag, dst2.src = true, tag2, dst1.addr
+		return nil
+	}
+	return &smc.SenseError{Key: 5, ASC: 0x20, ASCQ: 0x00}
+}
+
+func (f *fakeTarget) Close() error {
+	return nil
+}
+
+func newLibrary(f *fakeTarget) *mtx.Library {
+	return mtx.NewLibraryExecutor("/dev/sg3", &Executor{
+		Open: func(string) (Transport, error) { return f, nil },
+	})
+}
+
+func TestStatus(t *testing.T) {
+	out, _, err := (&Executor{
+		Open: func(string) (Transport, error) { return newFakeTarget(), nil },
+	}).Run(context.Background(), "/dev/sga", "status")
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	want := `  Storage Changer /dev/sga:2 Drives, 6 Slots ( 2 Import/Export )
+Data Transfer Element 0:Full (Storage Element 1 Loaded):VolumeTag = M00001L6
+Data Transfer Element 1:Empty
+      Storage Element 1:Empty
+      Storage Element 2:Empty
+      Storage Element 3:Full :VolumeTag=M00003L6
+      Storage Element 4:Full :VolumeTag=CLN004L6
+      Storage Element 5 IMPORT/EXPORT:Full :VolumeTag=M00002L6
+      Storage Element 6 IMPORT/EXPORT:Empty
+`
+	if string(out) != want {
+		t.Errorf("status: expected\n%s\ngot\n%s", want, out)
+	}
+}
+
+func TestLibraryLoad(t *testing.T) {
+	f := newFakeTarget()
+	lib := newLibrary(f)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Load(m.Slots["3"].Vol, m.Drives["1"]); err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+	last := f.cdbs[len(f.cdbs)-1]
+	want := smc.MoveMedium(0, 4098, 257)
+	if string(last) != string(want) {
+		t.Errorf("Load(): expected CDB % x, got % x", want, last)
+	}
+	m, err = lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if m.Drives["1"].Vol == nil || m.Drives["1"].Vol.ID != "M00003L6" || m.Drives["1"].Vol.Home != "3" {
+		t.Errorf("expected M00003L6 from slot 3 in drive 1, got %+v", m.Drives["1"].Vol)
+	}
+}
+
+func TestLibraryUnload(t *testing.T) {
+	f := newFakeTarget()
+	lib := newLibrary(f)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Unload(m.Drives["0"].Vol); err != nil {
+		t.Fatalf("Unload(): %v", err)
+	}
+	if e := f.find(4096); !e.full || e.tag != "M00001L6" {
+		t.Errorf("Unload(): expected M00001L6 at address 4096, got %+v", e)
+	}
+}
+
+func TestSenseError(t *testing.T) {
+	lib := newLibrary(newFakeTarget())
+	err := lib.Load(&mtx.Volume{ID: "NONE", Home: "2"}, mtx.Slot{ID: "1"})
+	s, ok := errors.Cause(err).(*smc.SenseError)
+	if !ok {
+		t.Fatalf("Load(): expected *smc.SenseError cause, got %v", err)
+	}
+	if s.Key != 5 || s.ASC != 0x3b || s.ASCQ != 0x0e {
+		t.Errorf("Load(): unexpected sense %v", s)
+	}
+}
+
+func TestMoveErrors(t *testing.T) {
+	// a changer fault after the move was issued keeps the sense data
+	f := newFakeTarget()
+	f.moveErr = &smc.SenseError{Key: smc.NotReady, ASC: 0x04, ASCQ: 0x83}
+	lib := newLibrary(f)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	err = lib.Load(m.Slots["3"].Vol, m.Drives["1"])
+	if errors.Cause(err) != mtx.ErrStateUnknown {
+		t.Errorf("Load(): expected cause ErrStateUnknown, got %v", err)
+	}
+	var s *smc.SenseError
+	if !errors.As(err, &s) || s.ASC != 0x04 || s.ASCQ != 0x83 {
+		t.Errorf("Load(): expected *smc.SenseError door open, got %v", err)
+	}
+	if _, ok := lib.Cached(); ok {
+		t.Errorf("Load(): expected cache invalidated")
+	}
+
+	// nothing moves if the device cannot be opened
+	opens := 0
+	lib = mtx.NewLibraryExecutor("/dev/sg3", &Executor{
+		Open: func(string) (Transport, error) {
+			if opens++; opens > 1 {
+				return nil, errors.New("open sg device: permission denied")
+			}
+			return newFakeTarget(), nil
+		},
+	})
+	if m, err = lib.Status(); err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	err = lib.Load(m.Slots["3"].Vol, m.Drives["1"])
+	var oe *OpenError
+	if !errors.As(err, &oe) || errors.Is(err, mtx.ErrStateUnknown) {
+		t.Errorf("Load(): expected *OpenError, got %v", err)
+	}
+	if _, ok := lib.Cached(); !ok {
+		t.Errorf("Load(): expected cache kept when the device did not open")
+	}
+}
+
+func TestExchangeDrive(t *testing.T) {
+	f := newFakeTarget()
+	lib := newLibrary(f)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Exchange(m.Drives["0"], m.Slots["3"]); err != nil {
+		t.Fatalf("Exchange(): %v", err)
+	}
+	last := f.cdbs[len(f.cdbs)-1]
+	want := smc.ExchangeMedium(0, 256, 4098, 256)
+	if string(last) != string(want) {
+		t.Errorf("Exchange(): expected CDB % x, got % x", want, last)
+	}
+	if d, s := f.find(256), f.find(4098); d.tag != "M00003L6" || s.tag != "M00001L6" {
+		t.Errorf("Exchange(): expected M00003L6 in drive and M00001L6 in slot, got %v %v", d.tag, s.tag)
+	}
+	c, _ := lib.Cached()
+	if v := c.Drives["0"].Vol; v == nil || v.ID != "M00003L6" || v.Home != "3" {
+		t.Errorf("Exchange(): expected M00003L6 in drive 0, got %+v", v)
+	}
+
+	// a changer without exchange support is refused before the exchange
+	f = newFakeTarget()
+	f.noExchange = true
+	lib = newLibrary(f)
+	if m, err = lib.Status(); err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Exchange(m.Drives["0"], m.Slots["3"]); errors.Cause(err) != mtx.ErrExchangeUnsupported {
+		t.Errorf("Exchange(): expected ErrExchangeUnsupported, got %v", err)
+	}
+	_, _, err = (&Executor{
+		Open: func(string) (Transport, error) { return f, nil },
+	}).Run(context.Background(), "/dev/sg3", "exchange", "D0", "3")
+	var ue *UnsupportedError
+	if !errors.As(err, &ue) || ue.Src != smc.DataTransfer || ue.Dst != smc.Storage {
+		t.Errorf("Run(exchange): expected *UnsupportedError, got %v", err)
+	}
+	for _, cdb := range f.cdbs {
+		if cdb[0] == smc.OpExchangeMedium {
+			t.Errorf("expected no EXCHANGE MEDIUM, got % x", cdb)
+		}
+	}
+}
+
+func TestInventory(t *testing.T) {
+	f := newFakeTarget()
+	if err := newLibrary(f).Inventory(); err != nil {
+		t.Fatalf("Inventory(): %v", err)
+	}
+	if len(f.cdbs) != 1 || f.cdbs[0][0] != smc.OpInitializeElementStatus {
+		t.Errorf("Inventory(): expected a single INITIALIZE ELEMENT STATUS, got % x", f.cdbs)
+	}
+}
+
+func TestElementMap(t *testing.T) {
+	lib := newLibrary(newFakeTarget())
+	em, err := lib.ElementMap()
+	if err != nil {
+		t.Fatalf("ElementMap(): %v", err)
+	}
+	want := mtx.ElementMap{
+		FirstTransport: 0, NumTransport: 1,
+		FirstStorage: 4096, NumStorage: 4,
+		FirstImportExport: 16, NumImportExport: 2,
+		FirstDrive: 256, NumDrives: 2,
+		DriveAddresses: []int{256, 257},
+		SlotAddresses:  []int{4096, 4097, 4098, 4099, 16, 17},
+	}
+	if !reflect.DeepEqual(*em, want) {
+		t.Errorf("ElementMap(): expected %+v, got %+v", want, *em)
+	}
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if m.Slots["3"].Address != 4098 {
+		t.Errorf("Status(): expected slot 3 at 4098, got %v", m.Slots["3"].Address)
+	}
+}
+
+func TestElementMapGaps(t *testing.T) {
+	// a changer with an empty magazine position between storage
+	// elements 2 and 3
+	f := newFakeTarget()
+	f.find(4098).addr = 4104
+	f.find(4099).addr = 4105
+	lib := newLibrary(f)
+	em, err := lib.ElementMap()
+	if err != nil {
+		t.Fatalf("ElementMap(): %v", err)
+	}
+	if a, err := em.Address(mtx.StorageElement, "3"); err != nil || a != 4104 {
+		t.Errorf("Address(storage, 3): expected 4104, got %v %v", a, err)
+	}
+	if typ, id, err := em.ID(4105); err != nil || typ != mtx.StorageElement || id != "4" {
+		t.Errorf("ID(4105): expected storage 4, got %v %v %v", typ, id, err)
+	}
+	if _, _, err := em.ID(4098); err == nil {
+		t.Errorf("ID(4098): expected error for address in the gap")
+	}
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if m.Slots["4"].Address != 4105 || m.Mboxes["5"].Address != 16 {
+		t.Errorf("Status(): unexpected addresses slot 4 %v mbox 5 %v",
+			m.Slots["4"].Address, m.Mboxes["5"].Address)
+	}
+}
+
+func TestElementMapStockMtx(t *testing.T) {
+	// stock mtx prints the element counts only
+	lib := mtx.NewLibraryExecutor("/dev/sg3", mtx.NewScriptedExecutor(
+		mtx.Step{Args: []string{"loaderinfo"}, Stdout: "Number of Storage Elements: 4\n"},
+	))
+	f := newFakeTarget()
+	lib.AddressExecutor = &Executor{
+		Open: func(string) (Transport, error) { return f, nil },
+	}
+	em, err := lib.ElementMap()
+	if err != nil {
+		t.Fatalf("ElementMap(): %v", err)
+	}
+	if em.FirstStorage != 4096 || em.FirstDrive != 256 || len(em.SlotAddresses) != 6 {
+		t.Errorf("ElementMap(): expected addresses from the element status, got %+v", *em)
+	}
+	for _, cdb := range f.cdbs {
+		if cdb[0] != smc.OpModeSense6 && cdb[0] != smc.OpReadElementStatus {
+			t.Errorf("ElementMap(): expected only MODE SENSE and READ ELEMENT STATUS, got % x", cdb)
+		}
+	}
+}
+
+func TestDrives(t *testing.T) {
+	for _, noDVCID := range []bool{false, true} {
+		f := newFakeTarget()
+		f.noDVCID = noDVCID
+		drives, err := (&Executor{
+			Open: func(string) (Transport, error) { return f, nil },
+		}).Drives(context.Background(), "/dev/sg3")
+		if err != nil {
+			t.Fatalf("Drives(): %v", err)
+		}
+		if len(drives) != 2 || drives[0].VolumeTag != "M00001L6" {
+			t.Fatalf("Drives(): unexpected drives %+v", drives)
+		}
+		for i, want := range []string{"IBM     ULT3580-TD6     1013000123", "IBM     ULT3580-TD6     1013000456"} {
+			id := drives[i].Identifier
+			switch {
+			case noDVCID && id != nil:
+				t.Errorf("Drives(): expected no identifier for drive %v, got %v", i, id)
+			case !noDVCID && (id == nil || id.String() != want):
+				t.Errorf("Drives(): expected identifier %q for drive %v, got %v", want, i, id)
+			}
+		}
+	}
+}
+
+// blockingTarget holds MOVE MEDIUM commands until release is closed
+type blockingTarget struct {
+	*fakeTarget
+	release chan struct{}
+	closed  chan struct{}
+}
+
+func (b *blockingTarget) Do(cdb []byte, dir Direction, data []byte, timeout time.Duration) error {
+	if cdb[0] == smc.OpMoveMedium {
+		<-b.release
+	}
+	return b.fakeTarget.Do(cdb, dir, data, timeout)
+}
+
+func (b *blockingTarget) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestCancelMove(t *testing.T) {
+	b := &blockingTarget{fakeTarget: newFakeTarget(), release: make(chan struct{}), closed: make(chan struct{})}
+	lib := mtx.NewLibraryExecutor("/dev/sg3", &Executor{
+		Open: func(string) (Transport, error) { return b, nil },
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := lib.LoadContext(ctx, &mtx.Volume{ID: "M00003L6", Home: "3"}, mtx.Slot{ID: "1"})
+	if errors.Cause(err) != mtx.ErrStateUnknown {
+		t.Errorf("LoadContext(): expected ErrStateUnknown, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("LoadContext(): expected return on cancel, took %v", d)
+	}
+	select {
+	case <-b.closed:
+		t.Fatalf("LoadContext(): device closed with a command in progress")
+	default:
+	}
+	close(b.release)
+	select {
+	case <-b.closed:
+	case <-time.After(time.Second):
+		t.Errorf("LoadContext(): device not closed after the command finished")
+	}
+}
+
+func TestTimeoutMillis(t *testing.T) {
+	for _, tc := range []struct {
+		d    time.Duration
+		want uint32
+	}{
+		{-time.Second, 1},
+		{0, 1},
+		{500 * time.Microsecond, 1},
+		{2 * time.Second, 2000},
+		{DefaultTimeout, 600000},
+		{100 * 24 * time.Hour, 1<<32 - 1},
+	} {
+		if got := timeoutMillis(tc.d); got != tc.want {
+			t.Errorf("timeoutMillis(%v): expected %v, got %v", tc.d, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+//go:build linux
+
+package sg
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/benmcclelland/mtx/scsi/smc"
+	"github.com/pkg/errors"
+)
+
+const (
+	sgIO           = 0x2285
+	sgDxferNone    = -1
+	sgDxferToDev   = -2
+	sgDxferFromDev = -3
+	senseLen       = 64
+	checkCondition = 0x02
+)
+
+// sgIOHdr mirrors struct sg_io_hdr from <scsi/sg.h>
+type sgIOHdr struct {
+	interfaceID    int32
+	dxferDirection int32
+	cmdLen         uint8
+	mxSbLen        uint8
+	iovecCount     uint16
+	dxferLen       uint32
+	dxferp         unsafe.Pointer
+	cmdp           unsafe.Pointer
+	sbp            unsafe.Pointer
+	timeout        uint32
+	flags          uint32
+	packID         int32
+	usrPtr         unsafe.Pointer
+	status         uint8
+	maskedStatus   uint8
+	msgStatus      uint8
+	sbLenWr        uint8
+	hostStatus     uint16
+	driverStatus   uint16
+	resid          int32
+	duration       uint32
+	info           uint32
+}
+
+type device struct {
+	f *os.File
+}
+
+// OpenSG opens a SCSI generic device node such as /dev/sg3
+func OpenSG(path string) (Transport, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "open sg device")
+	}
+	return &device{f: f}, nil
+}
+
+func (d *device) Do(cdb []byte, dir Direction, data []byte, timeout time.Duration) error {
+	sense := make([]byte, senseLen)
+	hdr := sgIOHdr{
+		interfaceID: 'S',
+		cmdLen:      uint8(len(cdb)),
+		mxSbLen:     senseLen,
+		dxferLen:    uint32(len(data)),
+		cmdp:        unsafe.Pointer(&cdb[0]),
+		sbp:         unsafe.Pointer(&sense[0]),
+		timeout:     timeoutMillis(timeout),
+	}
+	switch {
+	case dir == None || len(data) == 0:
+		hdr.dxferDirection = sgDxferNone
+		hdr.dxferLen = 0
+	case dir == ToDevice:
+		hdr.dxferDirection = sgDxferToDev
+		hdr.dxferp = unsafe.Pointer(&data[0])
+	default:
+		hdr.dxferDirection = sgDxferFromDev
+		hdr.dxferp = unsafe.Pointer(&data[0])
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.f.Fd(), sgIO, uintptr(unsafe.Pointer(&hdr)))
+	runtime.KeepAlive(cdb)
+	runtime.KeepAlive(data)
+	runtime.KeepAlive(sense)
+	if errno != 0 {
+		return errors.Wrap(errno, "SG_IO ioctl")
+	}
+	if hdr.status == checkCondition || hdr.sbLenWr > 0 && hdr.status != 0 {
+		s, err := smc.DecodeSense(sense[:hdr.sbLenWr])
+		if err != nil {
+			return errors.Wrap(err, "check condition")
+		}
+		return s
+	}
+	if hdr.status != 0 || hdr.hostStatus != 0 || hdr.driverStatus&0x0f != 0 {
+		return errors.Errorf("SG_IO failed: status %#x host %#x driver %#x",
+			hdr.status, hdr.hostStatus, hdr.driverStatus)
+	}
+	return nil
+}
+
+func (d *device) Close() error {
+	return d.f.Close()
+}
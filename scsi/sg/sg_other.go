@@ -0,0 +1,12 @@
+//go:build !linux
+
+package sg
+
+import (
+	"github.com/pkg/errors"
+)
+
+// OpenSG is only supported on Linux
+func OpenSG(path string) (Transport, error) {
+	return nil, errors.New("SG_IO is only supported on linux")
+}
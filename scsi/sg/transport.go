@@ -0,0 +1,55 @@
+/*
+Package sg drives SCSI media changers directly through the Linux SCSI
+generic (SG_IO) interface instead of forking the mtx executable.
+Executor accepts the same command lines as mtx and produces the same
+output, so it can be used as the Executor of an mtx.Library:
+
+	lib := mtx.NewLibraryExecutor("/dev/sg3", &sg.Executor{})
+
+Executor.Drives returns what mtx status leaves out, such as the element
+addresses and device identifiers of the drives.
+
+The SG_IO ioctl sits behind the Transport interface so that tests can
+answer CDBs with a fake target.
+*/
+package sg
+
+import (
+	"math"
+	"time"
+)
+
+// Direction is the data transfer direction of a SCSI command
+type Direction int
+
+const (
+	// None transfers no data
+	None Direction = iota
+	// FromDevice reads data from the device into the buffer
+	FromDevice
+	// ToDevice writes the buffer to the device
+	ToDevice
+)
+
+// Transport delivers SCSI commands to a device
+type Transport interface {
+	// Do issues cdb, transferring data in direction dir.  A command
+	// completing with CHECK CONDITION returns an *smc.SenseError.
+	Do(cdb []byte, dir Direction, data []byte, timeout time.Duration) error
+	// Close releases the device
+	Close() error
+}
+
+// timeoutMillis converts a command timeout to SG_IO milliseconds.  It
+// is at least 1, since 0 selects the driver default, and saturates
+// rather than wrapping.
+func timeoutMillis(d time.Duration) uint32 {
+	ms := d / time.Millisecond
+	switch {
+	case ms < 1:
+		return 1
+	case ms > math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(ms)
+}
@@ -0,0 +1,517 @@
+/*
+Package smc builds SCSI Media Changer (SMC-3) command descriptor blocks
+and decodes their responses.  It has no knowledge of how commands are
+delivered to a device, see package sg for an SG_IO transport.
+*/
+package smc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Operation codes for the commands built by this package
+const (
+	OpInitializeElementStatus = 0x07
+	OpInquiry                 = 0x12
+	OpModeSense6              = 0x1a
+	OpMoveMedium              = 0xa5
+	OpExchangeMedium          = 0xa6
+	OpReadElementStatus       = 0xb8
+)
+
+// ElementType is an SMC element type code
+type ElementType byte
+
+const (
+	// AllElements requests every element type in READ ELEMENT STATUS
+	AllElements ElementType = iota
+	// MediumTransport is a robot picker
+	MediumTransport
+	// Storage is a storage slot
+	Storage
+	// ImportExport is a mailbox slot
+	ImportExport
+	// DataTransfer is a drive
+	DataTransfer
+)
+
+func (t ElementType) String() string {
+	switch t {
+	case AllElements:
+		return "all"
+	case MediumTransport:
+		return "medium transport"
+	case Storage:
+		return "storage"
+	case ImportExport:
+		return "import/export"
+	case DataTransfer:
+		return "data transfer"
+	}
+	return fmt.Sprintf("element type %d", byte(t))
+}
+
+// Mode pages defined by SMC-3
+const (
+	PageElementAddressAssignment = 0x1d
+	PageTransportGeometry        = 0x1e
+	PageDeviceCapabilities       = 0x1f
+	// PageAll requests every supported mode page
+	PageAll = 0x3f
+)
+
+// InitializeElementStatus returns the CDB asking the changer to
+// inventory all elements
+func InitializeElementStatus() []byte {
+	return []byte{OpInitializeElementStatus, 0, 0, 0, 0, 0}
+}
+
+// ModeSense6 returns a MODE SENSE(6) CDB for page with block
+// descriptors disabled
+func ModeSense6(page byte, alloc byte) []byte {
+	return []byte{OpModeSense6, 0x08, page & 0x3f, 0, alloc, 0}
+}
+
+// MoveMedium returns the CDB to move media from src to dst using
+// the transport element
+func MoveMedium(transport, src, dst uint16) []byte {
+	cdb := make([]byte, 12)
+	cdb[0] = OpMoveMedium
+	binary.BigEndian.PutUint16(cdb[2:], transport)
+	binary.BigEndian.PutUint16(cdb[4:], src)
+	binary.BigEndian.PutUint16(cdb[6:], dst)
+	return cdb
+}
+
+// ExchangeMedium returns the CDB to move media from src to dst1 and
+// the media in dst1 to dst2 using the transport element
+func ExchangeMedium(transport, src, dst1, dst2 uint16) []byte {
+	cdb := make([]byte, 12)
+	cdb[0] = OpExchangeMedium
+	binary.BigEndian.PutUint16(cdb[2:], transport)
+	binary.BigEndian.PutUint16(cdb[4:], src)
+	binary.BigEndian.PutUint16(cdb[6:], dst1)
+	binary.BigEndian.PutUint16(cdb[8:], dst2)
+	return cdb
+}
+
+// StatusOptions selects the optional data in a READ ELEMENT STATUS response
+type StatusOptions struct {
+	// VolTag requests primary and alternate volume tags
+	VolTag bool
+	// CurData asks the changer not to move the robot to gather data
+	CurData bool
+	// DVCID requests device identifiers for data transfer elements
+	DVCID bool
+}
+
+// ReadElementStatus returns the CDB to read the status of count
+// elements of type t starting at address start, with alloc bytes of
+// response
+func ReadElementStatus(t ElementType, start, count uint16, alloc uint32, opts StatusOptions) []byte {
+	cdb := make([]byte, 12)
+	cdb[0] = OpReadElementStatus
+	cdb[1] = byte(t) & 0x0f
+	if opts.VolTag {
+		cdb[1] |= 0x10
+	}
+	binary.BigEndian.PutUint16(cdb[2:], start)
+	binary.BigEndian.PutUint16(cdb[4:], count)
+	if opts.CurData {
+		cdb[6] |= 0x02
+	}
+	if opts.DVCID {
+		cdb[6] |= 0x01
+	}
+	cdb[7] = byte(alloc >> 16)
+	cdb[8] = byte(alloc >> 8)
+	cdb[9] = byte(alloc)
+	return cdb
+}
+
+// Inquiry returns a standard INQUIRY CDB
+func Inquiry(alloc byte) []byte {
+	return []byte{OpInquiry, 0, 0, 0, alloc, 0}
+}
+
+// InquiryVPD returns an INQUIRY CDB for a vital product data page
+func InquiryVPD(page byte, alloc byte) []byte {
+	return []byte{OpInquiry, 0x01, page, 0, alloc, 0}
+}
+
+// ModePage is a raw mode page from a MODE SENSE response
+type ModePage struct {
+	Code byte
+	// Data is the page including its two byte header
+	Data []byte
+}
+
+// DecodeModeSense6 splits a MODE SENSE(6) response into its pages
+func DecodeModeSense6(b []byte) ([]ModePage, error) {
+	if len(b) < 4 {
+		return nil, errors.New("short mode parameter header")
+	}
+	n := int(b[0]) + 1
+	if n > len(b) {
+		n = len(b)
+	}
+	start := 4 + int(b[3])
+	if start > n {
+		return nil, errors.New("block descriptors exceed mode data")
+	}
+	var pages []ModePage
+	p := b[start:n]
+	for len(p) >= 2 {
+		plen := 2 + int(p[1])
+		if plen > len(p) {
+			return pages, errors.Errorf("mode page %#02x length %d exceeds data", p[0]&0x3f, plen)
+		}
+		pages = append(pages, ModePage{Code: p[0] & 0x3f, Data: p[:plen]})
+		p = p[plen:]
+	}
+	return pages, nil
+}
+
+func findPage(b []byte, code byte, min int) ([]byte, error) {
+	pages, err := DecodeModeSense6(b)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pages {
+		if p.Code == code {
+			if len(p.Data) < min {
+				return nil, errors.Errorf("mode page %#02x too short", code)
+			}
+			return p.Data, nil
+		}
+	}
+	return nil, errors.Errorf("mode page %#02x not found", code)
+}
+
+// AddressAssignment is the decoded element address assignment mode page
+type AddressAssignment struct {
+	FirstTransport, NumTransport       uint16
+	FirstStorage, NumStorage           uint16
+	FirstImportExport, NumImportExport uint16
+	FirstDataTransfer, NumDataTransfer uint16
+}
+
+// DecodeAddressAssignment decodes the MODE SENSE(6) response holding the
+// element address assignment page
+func DecodeAddressAssignment(b []byte) (AddressAssignment, error) {
+	p, err := findPage(b, PageElementAddressAssignment, 18)
+	if err != nil {
+		return AddressAssignment{}, err
+	}
+	u := func(i int) uint16 { return binary.BigEndian.Uint16(p[i:]) }
+	return AddressAssignment{
+		FirstTransport:    u(2),
+		NumTransport:      u(4),
+		FirstStorage:      u(6),
+		NumStorage:        u(8),
+		FirstImportExport: u(10),
+		NumImportExport:   u(12),
+		FirstDataTransfer: u(14),
+		NumDataTransfer:   u(16),
+	}, nil
+}
+
+// Capabilities is the decoded device capabilities mode page.  The
+// arrays are indexed by ElementType for MediumTransport through
+// DataTransfer.
+type Capabilities struct {
+	// Store reports which element types can hold media
+	Store [5]bool
+	// Move[src][dst] reports if MOVE MEDIUM from src to dst is supported
+	Move [5][5]bool
+	// Exchange[src][dst] reports if EXCHANGE MEDIUM is supported
+	Exchange [5][5]bool
+}
+
+// CanExchange reports if EXCHANGE MEDIUM is supported for any pair of
+// element types
+func (c Capabilities) CanExchange() bool {
+	for _, row := range c.Exchange {
+		for _, ok := range row {
+			if ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DecodeCapabilities decodes the MODE SENSE(6) response holding the
+// device capabilities page
+func DecodeCapabilities(b []byte) (Capabilities, error) {
+	p, err := findPage(b, PageDeviceCapabilities, 16)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	var c Capabilities
+	for t := MediumTransport; t <= DataTransfer; t++ {
+		bit := byte(1) << (t - 1)
+		c.Store[t] = p[2]&bit != 0
+		for d := MediumTransport; d <= DataTransfer; d++ {
+			dbit := byte(1) << (d - 1)
+			c.Move[t][d] = p[4+int(t)-1]&dbit != 0
+			c.Exchange[t][d] = p[12+int(t)-1]&dbit != 0
+		}
+	}
+	return c, nil
+}
+
+// Element is a decoded element status descriptor
+type Element struct {
+	Type    ElementType
+	Address uint16
+	// Full is set when the element holds media
+	Full bool
+	// ImpExp is set on import/export elements holding media placed
+	// there by an operator rather than the robot
+	ImpExp bool
+	// Except is set when the element is in an abnormal state
+	// described by ASC and ASCQ
+	Except bool
+	// Access is set when the robot can reach the element
+	Access bool
+	// InEnab and ExEnab report import/export element direction
+	InEnab, ExEnab bool
+	ASC, ASCQ      byte
+	// SourceValid is set when Source holds the address of the
+	// element the media was last moved from
+	SourceValid bool
+	Invert      bool
+	Source      uint16
+	// VolumeTag is the primary volume tag with padding removed
+	VolumeTag string
+	// AltVolumeTag is the alternate volume tag with padding removed
+	AltVolumeTag string
+	// Identifier is the device identifier of a data transfer element
+	// when requested with StatusOptions.DVCID
+	Identifier *Identifier
+}
+
+// Identifier is an element device identifier descriptor
+type Identifier struct {
+	CodeSet byte
+	Type    byte
+	ID      []byte
+}
+
+// String returns the identifier as text for ASCII and UTF-8 code sets
+// and hex otherwise
+func (i Identifier) String() string {
+	if i.CodeSet == 2 || i.CodeSet == 3 {
+		return strings.TrimRight(string(i.ID), " \x00")
+	}
+	return fmt.Sprintf("%x", i.ID)
+}
+
+// DecodeElementStatus decodes a READ ELEMENT STATUS response
+func DecodeElementStatus(b []byte) ([]Element, error) {
+	if len(b) < 8 {
+		return nil, errors.New("short element status header")
+	}
+	n := int(b[5])<<16 | int(b[6])<<8 | int(b[7])
+	if n > len(b)-8 {
+		n = len(b) - 8
+	}
+	data := b[8 : 8+n]
+	var elems []Element
+	for len(data) >= 8 {
+		t := ElementType(data[0] & 0x0f)
+		pvoltag := data[1]&0x80 != 0
+		avoltag := data[1]&0x40 != 0
+		dlen := int(binary.BigEndian.Uint16(data[2:]))
+		plen := int(data[5])<<16 | int(data[6])<<8 | int(data[7])
+		data = data[8:]
+		if plen > len(data) {
+			return elems, errors.Errorf("element status page length %d exceeds data", plen)
+		}
+		if dlen < 12 {
+			return elems, errors.Errorf("element descriptor length %d too short", dlen)
+		}
+		page := data[:plen]
+		data = data[plen:]
+		for len(page) >= dlen {
+			d := page[:dlen]
+			page = page[dlen:]
+			e := Element{
+				Type:        t,
+				Address:     binary.BigEndian.Uint16(d[0:]),
+				Full:        d[2]&0x01 != 0,
+				ImpExp:      d[2]&0x02 != 0,
+				Except:      d[2]&0x04 != 0,
+				Access:      d[2]&0x08 != 0,
+				ExEnab:      d[2]&0x10 != 0,
+				InEnab:      d[2]&0x20 != 0,
+				ASC:         d[4],
+				ASCQ:        d[5],
+				SourceValid: d[9]&0x80 != 0,
+				Invert:      d[9]&0x40 != 0,
+				Source:      binary.BigEndian.Uint16(d[10:]),
+			}
+			rest := d[12:]
+			if pvoltag && len(rest) >= 36 {
+				e.VolumeTag = volTag(rest[:32])
+				rest = rest[36:]
+			}
+			if avoltag && len(rest) >= 36 {
+				e.AltVolumeTag = volTag(rest[:32])
+				rest = rest[36:]
+			}
+			if len(rest) >= 4 && rest[3] > 0 && int(rest[3]) <= len(rest)-4 {
+				e.Identifier = &Identifier{
+					CodeSet: rest[0] & 0x0f,
+					Type:    rest[1] & 0x0f,
+					ID:      append([]byte(nil), rest[4:4+int(rest[3])]...),
+				}
+			}
+			elems = append(elems, e)
+		}
+	}
+	return elems, nil
+}
+
+// volTag trims the space and NUL padding from a volume identifier
+func volTag(b []byte) string {
+	return strings.TrimRight(string(b), " \x00")
+}
+
+// Sense keys
+const (
+	NoSense        = 0x0
+	RecoveredError = 0x1
+	NotReady       = 0x2
+	MediumError    = 0x3
+	HardwareError  = 0x4
+	IllegalRequest = 0x5
+	UnitAttention  = 0x6
+	DataProtect    = 0x7
+	AbortedCommand = 0xb
+)
+
+var senseKeys = [16]string{
+	"No Sense", "Recovered Error", "Not Ready", "Medium Error",
+	"Hardware Error", "Illegal Request", "Unit Attention", "Data Protect",
+	"Blank Check", "Vendor Specific", "Copy Aborted", "Aborted Command",
+	"Equal", "Volume Overflow", "Miscompare", "Reserved",
+}
+
+// ascText describes the additional sense codes commonly reported by
+// media changers
+var ascText = map[uint16]string{
+	0x0401: "logical unit is in process of becoming ready",
+	0x0403: "manual intervention required",
+	0x0483: "door open",
+	0x2101: "invalid element address",
+	0x2400: "invalid field in CDB",
+	0x2800: "not ready to ready change, medium may have changed",
+	0x2801: "import or export element accessed",
+	0x2900: "power on, reset, or bus device reset occurred",
+	0x3003: "cleaning cartridge installed",
+	0x3a00: "medium not present",
+	0x3b0d: "medium destination element full",
+	0x3b0e: "medium source element empty",
+	0x3b11: "medium magazine not accessible",
+	0x3b12: "medium magazine removed",
+	0x3b13: "medium magazine inserted",
+	0x3b14: "medium magazine locked",
+	0x3b15: "medium magazine unlocked",
+	0x3b18: "element disabled",
+	0x3b1a: "data transfer element removed",
+	0x5300: "media load or eject failed",
+	0x5302: "medium removal prevented",
+	0x8300: "label too short or too long",
+}
+
+// SenseError is a SCSI CHECK CONDITION with decoded sense data
+type SenseError struct {
+	Key, ASC, ASCQ byte
+	// Deferred is set for errors from an earlier command
+	Deferred bool
+}
+
+func (e *SenseError) Error() string {
+	s := fmt.Sprintf("%s (asc %#02x ascq %#02x)", senseKeys[e.Key&0x0f], e.ASC, e.ASCQ)
+	if d := e.Description(); d != "" {
+		s += ": " + d
+	}
+	if e.Deferred {
+		s = "deferred " + s
+	}
+	return s
+}
+
+// Description returns the text for the additional sense code, or ""
+// if it is not known
+func (e *SenseError) Description() string {
+	return ascText[uint16(e.ASC)<<8|uint16(e.ASCQ)]
+}
+
+// DecodeSense decodes fixed or descriptor format sense data
+func DecodeSense(b []byte) (*SenseError, error) {
+	if len(b) < 1 {
+		return nil, errors.New("empty sense data")
+	}
+	switch b[0] & 0x7f {
+	case 0x70, 0x71:
+		if len(b) < 14 {
+			return nil, errors.New("short fixed format sense data")
+		}
+		return &SenseError{Key: b[2] & 0x0f, ASC: b[12], ASCQ: b[13], Deferred: b[0]&0x7f == 0x71}, nil
+	case 0x72, 0x73:
+		if len(b) < 4 {
+			return nil, errors.New("short descriptor format sense data")
+		}
+		return &SenseError{Key: b[1] & 0x0f, ASC: b[2], ASCQ: b[3], Deferred: b[0]&0x7f == 0x73}, nil
+	}
+	return nil, errors.Errorf("unsupported sense data response code %#02x", b[0]&0x7f)
+}
+
+// InquiryData is the decoded standard INQUIRY response
+type InquiryData struct {
+	// DeviceType is the peripheral device type, 0x08 for media changers
+	DeviceType byte
+	Removable  bool
+	Version    byte
+	Vendor     string
+	Product    string
+	Revision   string
+}
+
+// DeviceTypeChanger is the peripheral device type of a media changer
+const DeviceTypeChanger = 0x08
+
+// DecodeInquiry decodes a standard INQUIRY response
+func DecodeInquiry(b []byte) (InquiryData, error) {
+	if len(b) < 36 {
+		return InquiryData{}, errors.New("short inquiry data")
+	}
+	trim := func(b []byte) string { return strings.TrimSpace(strings.TrimRight(string(b), "\x00")) }
+	return InquiryData{
+		DeviceType: b[0] & 0x1f,
+		Removable:  b[1]&0x80 != 0,
+		Version:    b[2],
+		Vendor:     trim(b[8:16]),
+		Product:    trim(b[16:32]),
+		Revision:   trim(b[32:36]),
+	}, nil
+}
+
+// DecodeSerialNumber decodes the unit serial number VPD page (0x80)
+func DecodeSerialNumber(b []byte) (string, error) {
+	if len(b) < 4 || b[1] != 0x80 {
+		return "", errors.New("unit serial number page not found")
+	}
+	n := int(binary.BigEndian.Uint16(b[2:]))
+	if n > len(b)-4 {
+		return "", errors.New("short unit serial number page")
+	}
+	return strings.TrimSpace(string(b[4 : 4+n])), nil
+}
@@ -0,0 +1,235 @@
+package smc
+
+import (
+	"bufio"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fixture reads a hex dump from testdata, ignoring # comments
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var b []byte
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		h, err := hex.DecodeString(strings.Join(strings.Fields(line), ""))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		b = append(b, h...)
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCDBs(t *testing.T) {
+	tests := []struct {
+		name string
+		cdb  []byte
+		want string
+	}{
+		{"initialize element status", InitializeElementStatus(), "070000000000"},
+		{"inquiry", Inquiry(96), "120000006000"},
+		{"inquiry vpd", InquiryVPD(0x80, 255), "12018000ff00"},
+		{"mode sense eaa", ModeSense6(PageElementAddressAssignment, 255), "1a081d00ff00"},
+		{"move medium", MoveMedium(0, 0x1002, 0x0101), "a50000001002010100000000"},
+		{"exchange medium", ExchangeMedium(0, 0x1002, 0x0101, 0x1003), "a60000001002010110030000"},
+		{"read element status storage", ReadElementStatus(Storage, 0x1000, 4, 0x400,
+			StatusOptions{VolTag: true}), "b81210000004000004000000"},
+		{"read element status drives dvcid", ReadElementStatus(DataTransfer, 0x100, 2, 0x10000,
+			StatusOptions{VolTag: true, CurData: true, DVCID: true}), "b81401000002030100000000"},
+		{"read element status all", ReadElementStatus(AllElements, 0, 0xffff, 0xffffff,
+			StatusOptions{}), "b8000000ffff00ffffff0000"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(tt.cdb); got != tt.want {
+			t.Errorf("%v: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestDecodeElementStatus(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []Element
+	}{
+		{"res_storage.hex", []Element{
+			{Type: Storage, Address: 0x1000, Full: true, Access: true, VolumeTag: "M00001L6"},
+			{Type: Storage, Address: 0x1001, Access: true},
+			{Type: Storage, Address: 0x1002, Full: true, Access: true, VolumeTag: "ABC 123"},
+			{Type: Storage, Address: 0x1003, Full: true, Access: true, Except: true, ASC: 0x83},
+		}},
+		{"res_drive_dvcid.hex", []Element{
+			{Type: DataTransfer, Address: 0x100, Full: true, Access: true,
+				SourceValid: true, Source: 0x1002, VolumeTag: "M00005L6",
+				Identifier: &Identifier{CodeSet: 2, Type: 1, ID: []byte("IBM     ULT3580-TD5     1013000123")}},
+			{Type: DataTransfer, Address: 0x101, Access: true,
+				Identifier: &Identifier{CodeSet: 2, Type: 1, ID: []byte("IBM     ULT3580-TD5     1013000456")}},
+		}},
+		{"res_ie_avoltag.hex", []Element{
+			{Type: ImportExport, Address: 0x10, Full: true, ImpExp: true, Access: true,
+				ExEnab: true, InEnab: true, VolumeTag: "M00002L6", AltVolumeTag: "ALT00002"},
+			{Type: ImportExport, Address: 0x11, Access: true, ExEnab: true, InEnab: true},
+		}},
+		{"res_all.hex", []Element{
+			{Type: MediumTransport, Address: 0},
+			{Type: Storage, Address: 0x1000, Full: true, Access: true, VolumeTag: "M00001L6"},
+			{Type: Storage, Address: 0x1001, Access: true},
+			{Type: DataTransfer, Address: 0x100, Access: true},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := DecodeElementStatus(fixture(t, tt.fixture))
+		if err != nil {
+			t.Errorf("%v: %v", tt.fixture, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: expected\n%+v\ngot\n%+v", tt.fixture, tt.want, got)
+		}
+	}
+}
+
+func TestDecodeElementStatusShort(t *testing.T) {
+	b := fixture(t, "res_storage.hex")
+	for _, n := range []int{0, 7, 12, 30} {
+		if _, err := DecodeElementStatus(b[:n]); n < 8 && err == nil {
+			t.Errorf("DecodeElementStatus(%d bytes): expected error", n)
+		}
+	}
+}
+
+func TestDecodeAddressAssignment(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    AddressAssignment
+	}{
+		{"mode_eaa.hex", AddressAssignment{
+			FirstTransport: 0, NumTransport: 1,
+			FirstStorage: 0x1000, NumStorage: 4,
+			FirstImportExport: 0x10, NumImportExport: 2,
+			FirstDataTransfer: 0x100, NumDataTransfer: 2,
+		}},
+		{"mode_eaa_bd.hex", AddressAssignment{
+			FirstTransport: 0, NumTransport: 1,
+			FirstStorage: 0x3e8, NumStorage: 300,
+			FirstImportExport: 0xa, NumImportExport: 10,
+			FirstDataTransfer: 0x1f4, NumDataTransfer: 8,
+		}},
+	}
+	for _, tt := range tests {
+		got, err := DecodeAddressAssignment(fixture(t, tt.fixture))
+		if err != nil {
+			t.Errorf("%v: %v", tt.fixture, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%v: expected %+v, got %+v", tt.fixture, tt.want, got)
+		}
+	}
+	if _, err := DecodeAddressAssignment(fixture(t, "mode_caps.hex")); err == nil {
+		t.Errorf("mode_caps.hex: expected missing page error")
+	}
+}
+
+func TestDecodeCapabilities(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		exchange bool
+	}{
+		{"mode_caps.hex", true},
+		{"mode_caps_noexchange.hex", false},
+	}
+	for _, tt := range tests {
+		c, err := DecodeCapabilities(fixture(t, tt.fixture))
+		if err != nil {
+			t.Errorf("%v: %v", tt.fixture, err)
+			continue
+		}
+		if c.CanExchange() != tt.exchange {
+			t.Errorf("%v: expected CanExchange %v", tt.fixture, tt.exchange)
+		}
+		if !c.Store[Storage] || !c.Store[DataTransfer] || c.Store[MediumTransport] {
+			t.Errorf("%v: unexpected Store %v", tt.fixture, c.Store)
+		}
+		if !c.Move[Storage][DataTransfer] || !c.Move[ImportExport][Storage] {
+			t.Errorf("%v: unexpected Move %v", tt.fixture, c.Move)
+		}
+		if tt.exchange && (!c.Exchange[Storage][DataTransfer] || !c.Exchange[DataTransfer][Storage]) {
+			t.Errorf("%v: unexpected Exchange %v", tt.fixture, c.Exchange)
+		}
+	}
+}
+
+func TestDecodeSense(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    SenseError
+		text    string
+	}{
+		{"sense_fixed.hex", SenseError{Key: IllegalRequest, ASC: 0x3b, ASCQ: 0x0e},
+			"Illegal Request (asc 0x3b ascq 0x0e): medium source element empty"},
+		{"sense_fixed_deferred.hex", SenseError{Key: UnitAttention, ASC: 0x28, ASCQ: 0x01, Deferred: true},
+			"deferred Unit Attention (asc 0x28 ascq 0x01): import or export element accessed"},
+		{"sense_desc.hex", SenseError{Key: IllegalRequest, ASC: 0x3b, ASCQ: 0x0d},
+			"Illegal Request (asc 0x3b ascq 0x0d): medium destination element full"},
+		{"sense_desc_door.hex", SenseError{Key: NotReady, ASC: 0x04, ASCQ: 0x83},
+			"Not Ready (asc 0x04 ascq 0x83): door open"},
+	}
+	for _, tt := range tests {
+		got, err := DecodeSense(fixture(t, tt.fixture))
+		if err != nil {
+			t.Errorf("%v: %v", tt.fixture, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("%v: expected %+v, got %+v", tt.fixture, tt.want, *got)
+		}
+		if got.Error() != tt.text {
+			t.Errorf("%v: expected %q, got %q", tt.fixture, tt.text, got.Error())
+		}
+	}
+	if _, err := DecodeSense([]byte{0x70, 0, 5}); err == nil {
+		t.Errorf("DecodeSense(short): expected error")
+	}
+}
+
+func TestDecodeInquiry(t *testing.T) {
+	got, err := DecodeInquiry(fixture(t, "inquiry.hex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := InquiryData{
+		DeviceType: DeviceTypeChanger,
+		Removable:  true,
+		Version:    5,
+		Vendor:     "IBM",
+		Product:    "03584L32",
+		Revision:   "A460",
+	}
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	sn, err := DecodeSerialNumber(fixture(t, "vpd_serial.hex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sn != "0000013LL123" {
+		t.Errorf("expected serial 0000013LL123, got %q", sn)
+	}
+}
@@ -0,0 +1,120 @@
+package mtx
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+// clone returns a deep copy of mi
+func (mi *MediaInfo) clone() *MediaInfo {
+	c := *mi
+	c.Drives = cloneSlots(mi.Drives)
+	c.Slots = cloneSlots(mi.Slots)
+	c.Mboxes = cloneSlots(mi.Mboxes)
+	if mi.Warnings != nil {
+		w := *mi.Warnings
+		w.Lines = slices.Clone(w.Lines)
+		c.Warnings = &w
+	}
+	c.index = maps.Clone(mi.index)
+	return &c
+}
+
+// cloneSlots copies an element map and the volumes in it
+func cloneSlots(m map[string]Slot) map[string]Slot {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]Slot, len(m))
+	for id, s := range m {
+		if s.Vol != nil {
+			v := *s.Vol
+			s.Vol = &v
+		}
+		c[id] = s
+	}
+	return c
+}
+
+// Cached returns a snapshot of the Library state as of the last Status
+// and the operations completed since.  It does not run mtx.  It returns
+// false if there is no valid state, because Status has not succeeded
+// or a move failed or was interrupted.
+func (l *Library) Cached() (*MediaInfo, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if !l.initialized {
+		return nil, false
+	}
+	return l.mi.clone(), true
+}
+
+// View calls fn with the cached Library state, avoiding the copy made
+// by Cached for large libraries.  Operations that change the state wait
+// until fn returns.  fn must not modify mi or keep any reference into it.
+// View returns false without calling fn if there is no valid state.
+func (l *Library) View(fn func(mi *MediaInfo)) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if !l.initialized {
+		return false
+	}
+	fn(&l.mi)
+	return true
+}
+
+// update calls fn to change the cached state and advances the
+// generation.  The Library lock must be held.
+func (l *Library) update(fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fn()
+	l.gen++
+	l.mi.Generation = l.gen
+}
+
+// current returns the cached state of vol, which may come from an
+// older snapshot.  The element vol was in is checked first, then
+// labeled volumes are found by barcode.  An error is returned if there
+// is a cached state that no longer holds the volume.  Without one, vol
+// is returned, with Location set to Home if neither is set.  The
+// Library lock must be held.
+func (l *Library) current(vol *Volume) (*Volume, error) {
+	if vol == nil {
+		return nil, errors.New("no volume given")
+	}
+	if !l.initialized {
+		if vol.Location != "" || vol.Drive != "" {
+			return vol, nil
+		}
+		v := *vol
+		v.Location = v.Home
+		return &v, nil
+	}
+	same := func(v *Volume) bool {
+		return v != nil && v.ID == vol.ID && v.AltID == vol.AltID
+	}
+	loc := vol.Location
+	if loc == "" && vol.Drive == "" {
+		loc = vol.Home
+	}
+	if vol.Drive != "" {
+		if v := l.mi.Drives[vol.Drive].Vol; same(v) {
+			return v, nil
+		}
+	} else if s, ok := l.mi.storageSlot(loc); ok && same(s.Vol) {
+		return s.Vol, nil
+	}
+	if vol.Labeled() {
+		tag := vol.ID
+		if tag == "" {
+			tag = vol.AltID
+		}
+		if s, err := l.mi.Locate(tag); err == nil && same(s.Vol) {
+			return s.Vol, nil
+		}
+	}
+	return nil, errors.Errorf("volume %v not found in cached state, run Status", vol.name())
+}
@@ -0,0 +1,163 @@
+package mtx
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGeneration(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"load", "3", "1"}},
+	))
+	if _, ok := lib.Cached(); ok {
+		t.Errorf("Cached(): expected no state before Status")
+	}
+	if lib.View(func(*MediaInfo) { t.Errorf("View(): called before Status") }) {
+		t.Errorf("View(): expected false before Status")
+	}
+
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if m.Generation != 1 {
+		t.Errorf("Status(): expected generation 1, got %v", m.Generation)
+	}
+	if err := lib.Load(m.Slots["3"].Vol, m.Drives["1"]); err != nil {
+		t.Fatalf("Load(): %v", err)
+	}
+	c, ok := lib.Cached()
+	if !ok || c.Generation != 2 {
+		t.Fatalf("Cached(): expected generation 2, got %v %v", c, ok)
+	}
+	if m.Generation != 1 || m.Slots["3"].Vol == nil || m.Slots["3"].Vol.Drive != "" {
+		t.Errorf("Load(): changed Status snapshot")
+	}
+
+	// snapshots do not share state with the Library or each other
+	c.Drives["1"].Vol.Drive = "changed"
+	delete(c.Slots, "1")
+	var gen uint64
+	if !lib.View(func(mi *MediaInfo) {
+		gen = mi.Generation
+		if mi.Drives["1"].Vol.Drive != "1" || len(mi.Slots) != 4 {
+			t.Errorf("View(): cached state changed through snapshot")
+		}
+	}) {
+		t.Errorf("View(): expected valid state")
+	}
+	if gen != 2 {
+		t.Errorf("View(): expected generation 2, got %v", gen)
+	}
+}
+
+func TestSnapshotRace(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", ExecFunc(func(ctx context.Context, device string, args ...string) ([]byte, []byte, error) {
+		if args[0] == "status" {
+			return []byte(mockStatus), nil, nil
+		}
+		return nil, nil, nil
+	}))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if err := lib.Load(m.Slots["3"].Vol, m.Drives["1"]); err != nil {
+				t.Errorf("Load(): %v", err)
+				return
+			}
+			if err := lib.Unload(m.Slots["3"].Vol); err != nil {
+				t.Errorf("Unload(): %v", err)
+				return
+			}
+			if i%50 == 0 {
+				if _, err := lib.Status(); err != nil {
+					t.Errorf("Status(): %v", err)
+					return
+				}
+			}
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if c, ok := lib.Cached(); ok {
+					if n := c.Volumes().Count(); n != 4 {
+						t.Errorf("Cached(): expected 4 volumes, got %v", n)
+					}
+				}
+				lib.View(func(mi *MediaInfo) {
+					_, _ = mi.Locate("M00003L6")
+				})
+				if v, ok := m.Volumes().WithBarcode("M00003L6").First(); !ok || v.Drive != "" {
+					t.Errorf("Status(): snapshot changed by later operations")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if m.Generation != 1 || m.Slots["3"].Vol == nil {
+		t.Errorf("Status(): snapshot changed by later operations")
+	}
+}
+
+func TestStaleVolume(t *testing.T) {
+	replaced := strings.NewReplacer(
+		"Element 3:Full :VolumeTag=M00003L6", "Element 3:Full :VolumeTag=X99999L6",
+		"VolumeTag = M00001L6", "VolumeTag = Y00000L6",
+	).Replace(mockStatus)
+	rec := &RecordingExecutor{Exec: NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: mockStatus},
+		Step{Args: []string{"status"}, Stdout: replaced},
+	)}
+	lib := NewLibraryExecutor("/dev/sga", rec)
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if _, err := lib.Status(); err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+
+	if err := lib.Load(m.Slots["3"].Vol, m.Drives["1"]); err == nil {
+		t.Errorf("Load(): expected error for volume no longer in slot 3")
+	}
+	if err := lib.Unload(m.Drives["0"].Vol); err == nil {
+		t.Errorf("Unload(): expected error for volume no longer in drive 0")
+	}
+	if err := lib.Transfer(m.Slots["3"].Vol, m.Slots["2"]); err == nil {
+		t.Errorf("Transfer(): expected error for volume no longer in slot 3")
+	}
+	if err := lib.Export(m.Drives["0"].Vol, m.Mboxes["6"]); err == nil {
+		t.Errorf("Export(): expected error for volume no longer in drive 0")
+	}
+	want := "move: storage element 3 holds X99999L6, not M00003L6, run Status"
+	if err := lib.Move(m.Slots["3"], m.Slots["2"]); err == nil || err.Error() != want {
+		t.Errorf("Move(): expected %q, got %v", want, err)
+	}
+	if err := lib.Load(nil, m.Drives["1"]); err == nil {
+		t.Errorf("Load(): expected error for nil volume")
+	}
+	if n := len(rec.Calls()); n != 2 {
+		t.Errorf("expected only the 2 status commands to run, got %v", n)
+	}
+}
@@ -0,0 +1,367 @@
+package mtx
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var summaryRxp = regexp.MustCompile(`\s*Storage Changer .*:(\d+) Drives, (\d+) Slots \( (\d+) Import/Export \)`)
+
+// maxLineErrors limits the unrecognized lines kept in a ParseError so
+// that garbage output does not end up held in memory
+const maxLineErrors = 100
+
+// LineError is a line of status output that was not recognized
+type LineError struct {
+	// Num is the 1 based line number
+	Num int
+	// Text is the offending line
+	Text string
+}
+
+// ParseError describes mtx status output that did not fully match
+// the expected format
+type ParseError struct {
+	// Lines are the first maxLineErrors unrecognized lines
+	Lines []LineError
+	// Duplicates are the first maxLineErrors element lines for an
+	// element that was already listed, all of them are counted in the
+	// Found counts
+	Duplicates []LineError
+	// Omitted is the number of unrecognized lines not kept in Lines
+	Omitted int
+	// Expected counts are from the summary line, Found counts are
+	// the number of element lines parsed, including duplicates
+	ExpectedDrives, FoundDrives int
+	ExpectedSlots, FoundSlots   int
+	ExpectedMboxes, FoundMboxes int
+}
+
+func (e *ParseError) problems() bool {
+	return len(e.Lines) > 0 || len(e.Duplicates) > 0 || e.Omitted > 0 ||
+		e.ExpectedDrives != e.FoundDrives ||
+		e.ExpectedSlots != e.FoundSlots ||
+		e.ExpectedMboxes != e.FoundMboxes
+}
+
+func (e *ParseError) Error() string {
+	var msgs []string
+	for _, l := range e.Lines {
+		msgs = append(msgs, fmt.Sprintf("line %d: unrecognized %q", l.Num, l.Text))
+	}
+	for _, l := range e.Duplicates {
+		msgs = append(msgs, fmt.Sprintf("line %d: duplicate element %q", l.Num, l.Text))
+	}
+	if e.Omitted > 0 {
+		msgs = append(msgs, fmt.Sprintf("%d more unrecognized lines", e.Omitted))
+	}
+	count := func(kind string, expected, found int) {
+		if expected != found {
+			msgs = append(msgs, fmt.Sprintf("expected %d %s, found %d", expected, kind, found))
+		}
+	}
+	count("drives", e.ExpectedDrives, e.FoundDrives)
+	count("slots", e.ExpectedSlots, e.FoundSlots)
+	count("import/export", e.ExpectedMboxes, e.FoundMboxes)
+	return "parse status: " + strings.Join(msgs, "; ")
+}
+
+// ParseStatusFunc parses "mtx status" output from r and calls fn for
+// each element in the order they are listed, without holding the whole
+// output in memory.  If fn returns an error, parsing stops and that
+// error is returned.  Unrecognized lines, duplicate elements and element
+// counts that do not match the summary line are returned as a
+// *ParseError once all of the output has been read, the same as the
+// MediaInfo.Warnings of Status.  fn is only called for the first
+// listing of an element.  Output of more than one changer is an error.
+func ParseStatusFunc(r io.Reader, fn func(Slot) error) error {
+	ss, err := newStatusScanner(r)
+	if err != nil {
+		return err
+	}
+	err = ss.each(fn)
+	if err != nil {
+		return err
+	}
+	if ss.warn.problems() {
+		return &ss.warn
+	}
+	return nil
+}
+
+// parseStatus parses "mtx status" output into a MediaInfo.  Problems
+// that do not prevent parsing are returned in MediaInfo.Warnings.  If
+// an element is listed more than once, the first listing is used.
+func parseStatus(r io.Reader) (MediaInfo, error) {
+	ss, err := newStatusScanner(r)
+	if err != nil {
+		return MediaInfo{}, err
+	}
+	warn := &ss.warn
+	dmap := make(map[string]Slot, sizeHint(warn.ExpectedDrives))
+	smap := make(map[string]Slot, sizeHint(warn.ExpectedSlots))
+	mmap := make(map[string]Slot, sizeHint(warn.ExpectedMboxes))
+	err = ss.each(func(s Slot) error {
+		switch s.Type {
+		case DataTransferElement:
+			dmap[s.ID] = s
+		case StorageElement:
+			smap[s.ID] = s
+		case ImportExport:
+			mmap[s.ID] = s
+		}
+		return nil
+	})
+	if err != nil {
+		return MediaInfo{}, err
+	}
+
+	m := MediaInfo{
+		NumDrives:       warn.ExpectedDrives,
+		NumSlots:        warn.ExpectedSlots,
+		NumImportExport: warn.ExpectedMboxes,
+		Drives:          dmap,
+		Slots:           smap,
+		Mboxes:          mmap,
+	}
+	m.buildIndex()
+	if warn.problems() {
+		m.Warnings = warn
+	}
+	return m, nil
+}
+
+// sizeHint bounds a map size taken from the summary line, which may
+// claim far more elements than the output contains
+func sizeHint(n int) int {
+	const max = 1 << 14
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// statusScanner tokenizes mtx status output a line at a time
+type statusScanner struct {
+	sc   *bufio.Scanner
+	line int
+	// seen holds the elements listed so far
+	seen map[elementKey]bool
+	// warn holds the summary and element counts and the problem lines
+	warn ParseError
+}
+
+// elementKey identifies an element in status output
+type elementKey struct {
+	Type SlotType
+	ID   string
+}
+
+// newStatusScanner reads the summary line from r
+func newStatusScanner(r io.Reader) (*statusScanner, error) {
+	ss := &statusScanner{sc: bufio.NewScanner(r), line: 1, seen: make(map[elementKey]bool)}
+	if !ss.sc.Scan() {
+		if err := ss.sc.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.Errorf("no summary output found")
+	}
+	match := summaryRxp.FindStringSubmatch(ss.sc.Text())
+	if len(match) != 4 {
+		return nil, errors.Errorf("no summary output found")
+	}
+	driveCnt, err := strconv.Atoi(match[1])
+	if err != nil {
+		return nil, errors.Wrap(err, "summary drives")
+	}
+	totslotCnt, err := strconv.Atoi(match[2])
+	if err != nil {
+		return nil, errors.Wrap(err, "summary slots")
+	}
+	mboxCnt, err := strconv.Atoi(match[3])
+	if err != nil {
+		return nil, errors.Wrap(err, "summary import/export")
+	}
+	if mboxCnt > totslotCnt {
+		return nil, errors.Errorf("summary: %d import/export slots exceed %d slots",
+			mboxCnt, totslotCnt)
+	}
+	ss.warn.ExpectedDrives = driveCnt
+	ss.warn.ExpectedSlots = totslotCnt - mboxCnt
+	ss.warn.ExpectedMboxes = mboxCnt
+	return ss, nil
+}
+
+// each counts every element line and calls fn for the first listing
+// of each element.  Duplicate elements and lines that are not
+// recognized are recorded.  A second summary line means the output of
+// more than one changer, which fails since element numbers repeat.
+func (ss *statusScanner) each(fn func(Slot) error) error {
+	for ss.sc.Scan() {
+		ss.line++
+		line := ss.sc.Text()
+		if summaryRxp.MatchString(line) {
+			return errors.Errorf("line %d: status of more than one changer, parse the output of each changer separately", ss.line)
+		}
+		s, ok := parseElement(line)
+		if ok {
+			switch s.Type {
+			case DataTransferElement:
+				ss.warn.FoundDrives++
+			case StorageElement:
+				ss.warn.FoundSlots++
+			case ImportExport:
+				ss.warn.FoundMboxes++
+			}
+			key := elementKey{s.Type, s.ID}
+			if ss.seen[key] {
+				if len(ss.warn.Duplicates) < maxLineErrors {
+					ss.warn.Duplicates = append(ss.warn.Duplicates, LineError{Num: ss.line, Text: line})
+				}
+				continue
+			}
+			ss.seen[key] = true
+			if err := fn(s); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if len(ss.warn.Lines) < maxLineErrors {
+			ss.warn.Lines = append(ss.warn.Lines, LineError{Num: ss.line, Text: line})
+		} else {
+			ss.warn.Omitted++
+		}
+	}
+	return ss.sc.Err()
+}
+
+const (
+	driveToken   = "Data Transfer Element "
+	storageToken = "Storage Element "
+	mboxToken    = " IMPORT/EXPORT"
+	fullToken    = "Full"
+	emptyToken   = "Empty"
+	loadedToken  = " (Storage Element "
+	unknownToken = " (Unknown Storage Element Loaded)"
+	tagToken     = ":VolumeTag"
+	altTagToken  = ":AlternateVolumeTag"
+)
+
+// parseElement parses a single element line such as
+//
+//	Data Transfer Element 0:Full (Storage Element 1 Loaded):VolumeTag = M00001L6
+//	      Storage Element 5 IMPORT/EXPORT:Empty
+func parseElement(line string) (Slot, bool) {
+	line = strings.TrimLeft(line, " \t")
+	s := Slot{Address: -1}
+	var rest string
+	switch {
+	case strings.HasPrefix(line, driveToken):
+		s.Type = DataTransferElement
+		s.ID, rest = number(line[len(driveToken):])
+	case strings.HasPrefix(line, storageToken):
+		s.Type = StorageElement
+		s.ID, rest = number(line[len(storageToken):])
+		if strings.HasPrefix(rest, mboxToken) {
+			s.Type = ImportExport
+			rest = rest[len(mboxToken):]
+		}
+	default:
+		return Slot{}, false
+	}
+	if s.ID == "" || !strings.HasPrefix(rest, ":") {
+		return Slot{}, false
+	}
+	rest = rest[1:]
+	if strings.HasPrefix(rest, emptyToken) {
+		return s, true
+	}
+	if !strings.HasPrefix(rest, fullToken) {
+		return Slot{}, false
+	}
+	rest = rest[len(fullToken):]
+
+	vol := Volume{Home: s.ID, Location: s.ID}
+	if s.Type == DataTransferElement {
+		vol.Location = ""
+		var ok bool
+		vol.Drive = s.ID
+		if vol.Home, rest, ok = loadedFrom(rest); !ok {
+			return Slot{}, false
+		}
+	}
+	var ok bool
+	if vol.ID, vol.AltID, ok = volumeTags(rest); !ok {
+		return Slot{}, false
+	}
+	s.Vol = &vol
+	return s, true
+}
+
+// number splits the leading decimal digits from s
+func number(s string) (string, string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// loadedFrom parses the source element of a full drive, which is
+// empty if mtx does not know where the volume came from
+func loadedFrom(s string) (home, rest string, ok bool) {
+	if strings.HasPrefix(s, unknownToken) {
+		return "", s[len(unknownToken):], true
+	}
+	if !strings.HasPrefix(s, loadedToken) {
+		return "", "", false
+	}
+	home, s = number(s[len(loadedToken):])
+	if home == "" || !strings.HasPrefix(s, " Loaded)") {
+		return "", "", false
+	}
+	return home, s[len(" Loaded)"):], true
+}
+
+// volumeTags parses the optional primary and alternate volume tags at
+// the end of a full element line.  Tags may be padded or contain spaces.
+func volumeTags(s string) (id, alt string, ok bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, tagToken) {
+		s = s[len(tagToken):]
+		end := strings.Index(s, altTagToken)
+		if end < 0 {
+			end = len(s)
+		}
+		if id, ok = tagValue(s[:end]); !ok {
+			return "", "", false
+		}
+		s = s[end:]
+	}
+	if strings.HasPrefix(s, altTagToken) {
+		if alt, ok = tagValue(s[len(altTagToken):]); !ok {
+			return "", "", false
+		}
+		s = ""
+	}
+	return id, alt, s == ""
+}
+
+// tagValue parses " = TAG" allowing the spaces around the equals sign
+// to be left out
+func tagValue(s string) (string, bool) {
+	s = strings.TrimPrefix(s, " ")
+	if !strings.HasPrefix(s, "=") {
+		return "", false
+	}
+	return strings.TrimSpace(s[1:]), true
+}
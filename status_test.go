@@ -0,0 +1,266 @@
+package mtx
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata/status")
+
+// golden is the expected result of parsing a testdata/status file
+type golden struct {
+	Info    MediaInfo
+	Error   string `json:",omitempty"`
+	Warning string `json:",omitempty"`
+}
+
+func TestStatusGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "status", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no status files found in testdata/status")
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".txt")
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			var g golden
+			g.Info, err = parseStatus(f)
+			if err != nil {
+				g.Error = err.Error()
+			}
+			if g.Info.Warnings != nil {
+				g.Warning = g.Info.Warnings.Error()
+			}
+			got, err := json.MarshalIndent(g, "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, '\n')
+
+			gf := strings.TrimSuffix(file, ".txt") + ".json"
+			if *update {
+				if err := ioutil.WriteFile(gf, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(gf)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("parseStatus(%v): result differs from %v\ngot:\n%s", file, gf, got)
+			}
+		})
+	}
+}
+
+// checkMediaInfo reports MediaInfo fields that are inconsistent with
+// each other
+func checkMediaInfo(t *testing.T, m MediaInfo) {
+	t.Helper()
+	check := func(kind string, typ SlotType, slots map[string]Slot) {
+		for key, s := range slots {
+			if s.ID != key {
+				t.Errorf("%v[%q].ID = %q", kind, key, s.ID)
+			}
+			if s.Type != typ {
+				t.Errorf("%v[%q].Type = %v", kind, key, s.Type)
+			}
+			if s.Vol == nil {
+				continue
+			}
+			if typ == DataTransferElement {
+				if s.Vol.Drive != key {
+					t.Errorf("%v[%q].Vol.Drive = %q", kind, key, s.Vol.Drive)
+				}
+				continue
+			}
+			if s.Vol.Drive != "" {
+				t.Errorf("%v[%q].Vol.Drive = %q, expected empty", kind, key, s.Vol.Drive)
+			}
+			if s.Vol.Home != key {
+				t.Errorf("%v[%q].Vol.Home = %q", kind, key, s.Vol.Home)
+			}
+		}
+	}
+	check("Drives", DataTransferElement, m.Drives)
+	check("Slots", StorageElement, m.Slots)
+	check("Mboxes", ImportExport, m.Mboxes)
+	if m.NumDrives < 0 || m.NumSlots < 0 || m.NumImportExport < 0 {
+		t.Errorf("negative element count: %v drives, %v slots, %v import/export",
+			m.NumDrives, m.NumSlots, m.NumImportExport)
+	}
+	if w := m.Warnings; w != nil && len(w.Lines) > maxLineErrors {
+		t.Errorf("%v unrecognized lines kept, expected at most %v", len(w.Lines), 100)
+	}
+}
+
+func FuzzParseStatus(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("testdata", "status", "*.txt"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Add([]byte(""))
+	f.Add([]byte("  Storage Changer /dev/sg0:1 Drives, 1 Slots ( 2 Import/Export )\n"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		m, err := parseStatus(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		checkMediaInfo(t, m)
+	})
+}
+
+func TestParseStatusSummary(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"\n",
+		"Data Transfer Element 0:Empty\n",
+		"  Storage Changer /dev/sg0:99999999999999999999 Drives, 1 Slots ( 0 Import/Export )\n",
+		"  Storage Changer /dev/sg0:1 Drives, 1 Slots ( 2 Import/Export )\n",
+		"  Storage Changer /dev/sg0: Drives, 1 Slots ( 0 Import/Export )\n",
+	} {
+		if _, err := parseStatus(strings.NewReader(s)); err == nil {
+			t.Errorf("parseStatus(%q): expected error", s)
+		}
+	}
+}
+
+func TestParseStatusLongLine(t *testing.T) {
+	r := io.MultiReader(
+		strings.NewReader("  Storage Changer /dev/sg0:1 Drives, 1 Slots ( 0 Import/Export )\n"),
+		strings.NewReader("      Storage Element 1:Full :VolumeTag="),
+		io.LimitReader(repeatReader('A'), 1<<30))
+	_, err := parseStatus(r)
+	if errors.Cause(err) != bufio.ErrTooLong {
+		t.Errorf("parseStatus(): expected %v, got %v", bufio.ErrTooLong, err)
+	}
+}
+
+func TestParseStatusGarbage(t *testing.T) {
+	const n = 100000
+	var buf bytes.Buffer
+	buf.WriteString("  Storage Changer /dev/sg0:0 Drives, 0 Slots ( 0 Import/Export )\n")
+	for i := 0; i < n; i++ {
+		buf.WriteString("mtx: Request Sense: Long Report=yes\n")
+	}
+	m, err := parseStatus(&buf)
+	if err != nil {
+		t.Fatalf("parseStatus(): %v", err)
+	}
+	if m.Warnings == nil {
+		t.Fatal("parseStatus(): expected warnings")
+	}
+	if len(m.Warnings.Lines) != maxLineErrors {
+		t.Errorf("parseStatus(): expected %v lines, got %v", maxLineErrors, len(m.Warnings.Lines))
+	}
+	if m.Warnings.Omitted != n-maxLineErrors {
+		t.Errorf("parseStatus(): expected %v omitted, got %v", n-maxLineErrors, m.Warnings.Omitted)
+	}
+	if !strings.Contains(m.Warnings.Error(), "99900 more unrecognized lines") {
+		t.Errorf("parseStatus(): unexpected error %q", m.Warnings.Error())
+	}
+}
+
+func TestParseStatusDuplicates(t *testing.T) {
+	out := mockStatus + "      Storage Element 3:Empty\n"
+	m, err := parseStatus(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("parseStatus(): %v", err)
+	}
+	if m.Warnings == nil || len(m.Warnings.Duplicates) != 1 || m.Warnings.Duplicates[0].Num != 10 {
+		t.Fatalf("parseStatus(): expected duplicate on line 10, got %+v", m.Warnings)
+	}
+	if m.Warnings.FoundSlots != 5 {
+		t.Errorf("parseStatus(): expected 5 slots found, got %v", m.Warnings.FoundSlots)
+	}
+	want := `parse status: line 10: duplicate element "      Storage Element 3:Empty"; expected 4 slots, found 5`
+	if m.Warnings.Error() != want {
+		t.Errorf("parseStatus(): expected %q, got %q", want, m.Warnings.Error())
+	}
+	if m.Slots["3"].Vol == nil {
+		t.Errorf("parseStatus(): expected the first listing of slot 3 kept")
+	}
+}
+
+func TestParseStatusFuncWarnings(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "status", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		out, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, perr := parseStatus(bytes.NewReader(out))
+		n := 0
+		err = ParseStatusFunc(bytes.NewReader(out), func(Slot) error {
+			n++
+			return nil
+		})
+		want := perr
+		if want == nil && m.Warnings != nil {
+			want = m.Warnings
+		}
+		if fmt.Sprint(err) != fmt.Sprint(want) {
+			t.Errorf("ParseStatusFunc(%v): expected %v, got %v", file, want, err)
+		}
+		if perr != nil {
+			continue
+		}
+		if elems := len(m.Drives) + len(m.Slots) + len(m.Mboxes); n != elems {
+			t.Errorf("ParseStatusFunc(%v): expected %v elements, got %v", file, elems, n)
+		}
+	}
+}
+
+// repeatReader is an endless stream of one byte
+type repeatReader byte
+
+func (r repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r)
+	}
+	return len(p), nil
+}
+
+func TestStatusMultiChanger(t *testing.T) {
+	out, err := ioutil.ReadFile(filepath.Join("testdata", "status", "multi-changer.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: string(out)}))
+	lib.Lenient = true
+	if _, err := lib.Status(); err == nil || !strings.Contains(err.Error(), "more than one changer") {
+		t.Errorf("Status(): expected error for more than one changer even when lenient, got %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package mtx
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// UnloadPolicy chooses the empty storage slot used by UnloadAnywhere
+type UnloadPolicy int
+
+const (
+	// LowestSlot picks the lowest numbered empty storage slot
+	LowestSlot UnloadPolicy = iota
+	// NearestSlot picks the empty storage slot numbered closest to the
+	// home slot of the volume, the lower one on a tie.  Without a
+	// numeric home slot it is the same as LowestSlot.
+	NearestSlot
+)
+
+// String returns the name of the policy
+func (p UnloadPolicy) String() string {
+	switch p {
+	case LowestSlot:
+		return "lowest"
+	case NearestSlot:
+		return "nearest"
+	}
+	return "unknown"
+}
+
+// UnloadTo will attempt to move volume from its drive to the empty
+// storage or mailbox element slot.  A storage slot becomes the new home
+// slot of the volume.  vol may come from any earlier snapshot, it is
+// not modified.
+func (l *Library) UnloadTo(vol *Volume, slot Slot) error {
+	return l.UnloadToContext(context.Background(), vol, slot)
+}
+
+// UnloadToContext is like UnloadTo but gives up if ctx is done before
+// the Library lock is acquired.  If ctx is done while the unload is in
+// progress, an error with cause ErrStateUnknown is returned.
+func (l *Library) UnloadToContext(ctx context.Context, vol *Volume, slot Slot) error {
+	return l.UnloadToIf(ctx, vol, slot)
+}
+
+// UnloadToIf is like UnloadToContext but first checks conds against the
+// cached state.  If one does not hold, a *ConflictError is returned
+// without running mtx.
+func (l *Library) UnloadToIf(ctx context.Context, vol *Volume, slot Slot, conds ...Condition) error {
+	if err := l.lock(ctx); err != nil {
+		return errors.Wrap(err, "unloadto")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("unloadto", conds); err != nil {
+		return err
+	}
+	src, err := l.driveOf(vol)
+	if err != nil {
+		return errors.Wrap(err, "unloadto")
+	}
+	if slot.Type == DataTransferElement {
+		return errors.Errorf("unloadto: cannot unload into drive %v", slot.ID)
+	}
+	return errors.Wrap(l.transferHome(ctx, src, slot, true), "unloadto")
+}
+
+// UnloadAnywhere will attempt to move volume from its drive to an empty
+// storage slot and returns the slot used, which becomes the new home
+// slot of the volume.  The home slot is used if it is empty, otherwise
+// policy picks from the empty storage slots that are not the home of
+// another volume away from it.  UnloadAnywhere requires the cached
+// state from Status.
+func (l *Library) UnloadAnywhere(vol *Volume, policy UnloadPolicy) (Slot, error) {
+	return l.UnloadAnywhereContext(context.Background(), vol, policy)
+}
+
+// UnloadAnywhereContext is like UnloadAnywhere but gives up if ctx is
+// done before the Library lock is acquired.  If ctx is done while the
+// unload is in progress, an error with cause ErrStateUnknown is
+// returned.
+func (l *Library) UnloadAnywhereContext(ctx context.Context, vol *Volume, policy UnloadPolicy) (Slot, error) {
+	return l.UnloadAnywhereIf(ctx, vol, policy)
+}
+
+// UnloadAnywhereIf is like UnloadAnywhereContext but first checks conds
+// against the cached state.  If one does not hold, a *ConflictError is
+// returned without running mtx.
+func (l *Library) UnloadAnywhereIf(ctx context.Context, vol *Volume, policy UnloadPolicy, conds ...Condition) (Slot, error) {
+	if err := l.lock(ctx); err != nil {
+		return Slot{}, errors.Wrap(err, "unloadanywhere")
+	}
+	defer l.unlock()
+
+	if err := l.checkConditions("unloadanywhere", conds); err != nil {
+		return Slot{}, err
+	}
+	if !l.initialized {
+		return Slot{}, errors.New("unloadanywhere: no cached state, run Status first")
+	}
+	src, err := l.driveOf(vol)
+	if err != nil {
+		return Slot{}, errors.Wrap(err, "unloadanywhere")
+	}
+	dst, ok := l.emptySlot(src.Vol, policy)
+	if !ok {
+		return Slot{}, errors.New("unloadanywhere: no empty storage slot")
+	}
+	return dst, errors.Wrap(l.transferHome(ctx, src, dst, true), "unloadanywhere")
+}
+
+// driveOf returns the drive holding vol.  The Library lock must be held.
+func (l *Library) driveOf(vol *Volume) (Slot, error) {
+	vol, err := l.current(vol)
+	if err != nil {
+		return Slot{}, err
+	}
+	if vol.Drive == "" {
+		return Slot{}, errors.Errorf("volume %v not currently in drive", vol.name())
+	}
+	return l.elementOf(vol)
+}
+
+// emptySlot picks an empty storage slot for vol: its home slot if that
+// is empty, otherwise the slot chosen by policy from those that are not
+// the home of another volume away from it.  The Library lock must be
+// held and the cache valid.
+func (l *Library) emptySlot(vol *Volume, policy UnloadPolicy) (Slot, bool) {
+	if s, ok := l.mi.Slots[vol.Home]; ok && s.Vol == nil {
+		return s, true
+	}
+	reserved := make(map[string]bool)
+	for s := range l.mi.AllElements() {
+		if s.Vol != nil && s.Vol != vol && s.Vol.Home != "" && s.Vol.Home != s.Vol.Location {
+			reserved[s.Vol.Home] = true
+		}
+	}
+	home, err := strconv.Atoi(vol.Home)
+	nearest := policy == NearestSlot && err == nil
+	var first, best Slot
+	found, bestDist := false, 0
+	for s := range l.mi.EmptySlots() {
+		if reserved[s.ID] {
+			continue
+		}
+		if !nearest {
+			return s, true
+		}
+		if first.ID == "" {
+			first = s
+		}
+		n, err := strconv.Atoi(s.ID)
+		if err != nil {
+			continue
+		}
+		dist := n - home
+		if dist < 0 {
+			dist = -dist
+		}
+		// EmptySlots is in slot order, so the lower slot wins a tie
+		if !found || dist < bestDist {
+			best, found, bestDist = s, true, dist
+		}
+	}
+	if !found && first.ID != "" {
+		return first, true
+	}
+	return best, found
+}
@@ -0,0 +1,151 @@
+package mtx
+
+import (
+	"context"
+	"testing"
+)
+
+const unloadStatus = `  Storage Changer /dev/sga:2 Drives, 8 Slots ( 1 Import/Export )
+Data Transfer Element 0:Full (Storage Element 5 Loaded):VolumeTag = M00005L6
+Data Transfer Element 1:Full (Unknown Storage Element Loaded):VolumeTag = M00009L6
+      Storage Element 1:Empty
+      Storage Element 2:Full :VolumeTag=M00002L6
+      Storage Element 3:Empty
+      Storage Element 4:Full :VolumeTag=M00004L6
+      Storage Element 5:Full :VolumeTag=M00006L6
+      Storage Element 6:Full :VolumeTag=M00007L6
+      Storage Element 7:Empty
+      Storage Element 8 IMPORT/EXPORT:Empty
+`
+
+func TestUnloadTo(t *testing.T) {
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: unloadStatus},
+		Step{Args: []string{"unload", "3", "0"}},
+		Step{Args: []string{"unload", "8", "1"}},
+	))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Unload(m.Drives["0"].Vol); err == nil {
+		t.Errorf("Unload(): expected error for full home slot")
+	}
+	if err := lib.UnloadTo(m.Drives["0"].Vol, m.Slots["2"]); err == nil {
+		t.Errorf("UnloadTo(): expected error for full slot")
+	}
+	if err := lib.UnloadTo(m.Drives["0"].Vol, m.Drives["1"]); err == nil {
+		t.Errorf("UnloadTo(): expected error for drive")
+	}
+	if err := lib.UnloadTo(m.Slots["2"].Vol, m.Slots["3"]); err == nil {
+		t.Errorf("UnloadTo(): expected error for volume not in drive")
+	}
+	if err := lib.UnloadTo(m.Drives["0"].Vol, m.Slots["3"]); err != nil {
+		t.Fatalf("UnloadTo(): %v", err)
+	}
+	c, _ := lib.Cached()
+	if v := c.Slots["3"].Vol; v == nil || v.ID != "M00005L6" || v.Home != "3" || v.Location != "3" || v.Drive != "" {
+		t.Errorf("UnloadTo(): expected M00005L6 with home 3, got %+v", v)
+	}
+	if c.Drives["0"].Vol != nil {
+		t.Errorf("UnloadTo(): expected drive 0 empty")
+	}
+
+	// a mailbox does not become the home slot
+	if err := lib.UnloadTo(m.Drives["1"].Vol, m.Mboxes["8"]); err != nil {
+		t.Fatalf("UnloadTo(): %v", err)
+	}
+	c, _ = lib.Cached()
+	if v := c.Mboxes["8"].Vol; v == nil || v.ID != "M00009L6" || v.Home != "8" || v.Location != "8" {
+		t.Errorf("UnloadTo(): expected M00009L6 in mailbox 8, got %+v", v)
+	}
+}
+
+func TestUnloadAnywhere(t *testing.T) {
+	for _, tc := range []struct {
+		policy UnloadPolicy
+		drive  string
+		want   string
+	}{
+		{LowestSlot, "0", "1"},
+		{NearestSlot, "0", "3"},
+		{NearestSlot, "1", "1"},
+	} {
+		lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+			Step{Args: []string{"status"}, Stdout: unloadStatus},
+			Step{Args: []string{"unload", tc.want, tc.drive}},
+		))
+		m, err := lib.Status()
+		if err != nil {
+			t.Fatalf("Status(): %v", err)
+		}
+		vol := m.Drives[tc.drive].Vol
+		dst, err := lib.UnloadAnywhere(vol, tc.policy)
+		if err != nil || dst.ID != tc.want {
+			t.Errorf("UnloadAnywhere(%v, %v): expected slot %v, got %v %v", vol.ID, tc.policy, tc.want, dst.ID, err)
+			continue
+		}
+		c, _ := lib.Cached()
+		if v := c.Slots[tc.want].Vol; v == nil || v.ID != vol.ID || v.Home != tc.want {
+			t.Errorf("UnloadAnywhere(%v, %v): expected home %v, got %+v", vol.ID, tc.policy, tc.want, v)
+		}
+	}
+
+	// slots are not picked while their volume is away, except for the
+	// home slot of the volume being unloaded
+	lib := NewLibraryExecutor("/dev/sga", NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: unloadStatus},
+		Step{Args: []string{"transfer", "2", "1"}},
+		Step{Args: []string{"unload", "3", "1"}},
+		Step{Args: []string{"transfer", "5", "7"}},
+		Step{Args: []string{"unload", "5", "0"}},
+	))
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	if err := lib.Move(m.Slots["2"], m.Slots["1"]); err != nil {
+		t.Fatalf("Move(): %v", err)
+	}
+	if dst, err := lib.UnloadAnywhere(m.Drives["1"].Vol, LowestSlot); err != nil || dst.ID != "3" {
+		t.Errorf("UnloadAnywhere(): expected slot 3, got %v %v", dst.ID, err)
+	}
+	if err := lib.Move(m.Slots["5"], m.Slots["7"]); err != nil {
+		t.Fatalf("Move(): %v", err)
+	}
+	if dst, err := lib.UnloadAnywhere(m.Drives["0"].Vol, NearestSlot); err != nil || dst.ID != "5" {
+		t.Errorf("UnloadAnywhere(): expected home slot 5, got %v %v", dst.ID, err)
+	}
+	if _, err := lib.UnloadAnywhere(m.Slots["4"].Vol, LowestSlot); err == nil {
+		t.Errorf("UnloadAnywhere(): expected error for volume not in drive")
+	}
+}
+
+func TestUnloadIf(t *testing.T) {
+	rec := &RecordingExecutor{Exec: NewScriptedExecutor(
+		Step{Args: []string{"status"}, Stdout: unloadStatus},
+		Step{Args: []string{"unload", "3", "0"}},
+	)}
+	lib := NewLibraryExecutor("/dev/sga", rec)
+	ctx := context.Background()
+	m, err := lib.Status()
+	if err != nil {
+		t.Fatalf("Status(): %v", err)
+	}
+	want := "unloadto: conflict: expected storage 4 empty, found storage 4 holding M00004L6"
+	err = lib.UnloadToIf(ctx, m.Drives["0"].Vol, m.Slots["3"], Holding(StorageElement, "3", ""), Holding(StorageElement, "4", ""))
+	if err == nil || err.Error() != want {
+		t.Errorf("UnloadToIf(): expected %q, got %v", want, err)
+	}
+	_, err = lib.UnloadAnywhereIf(ctx, m.Drives["0"].Vol, LowestSlot, AtGeneration(m.Generation+1))
+	if _, ok := err.(*ConflictError); !ok {
+		t.Errorf("UnloadAnywhereIf(): expected *ConflictError, got %v", err)
+	}
+	if n := len(rec.Calls()); n != 1 {
+		t.Errorf("expected conflicts not to run mtx, got %v calls", n)
+	}
+	dst, err := lib.UnloadAnywhereIf(ctx, m.Drives["0"].Vol, NearestSlot, AtGeneration(m.Generation))
+	if err != nil || dst.ID != "3" {
+		t.Errorf("UnloadAnywhereIf(): expected slot 3, got %v %v", dst.ID, err)
+	}
+}